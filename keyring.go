@@ -1,10 +1,32 @@
 package keyring
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// providerMu guards provider.
+	providerMu sync.RWMutex
+	// provider used by the package level functions, set by SetDefault or
+	// detected on first use.
+	provider Keyring
+)
 
-// provider set in the init function by the relevant os file e.g.:
-// keyring_unix.go
-var provider Keyring = fallbackServiceProvider{}
+// detectProvider chooses the keyring for the current platform, see
+// WithAutoDetect.
+func detectProvider() (Keyring, error) {
+	cfg, err := loadDetectConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.backends != nil {
+		return openPreferred(cfg.backends, cfg.configs, true)
+	}
+	return openPreferred(backendPreference, cfg.configs, false)
+}
 
 var (
 	// ErrNotFound is the expected error if the secret isn't found in the
@@ -15,8 +37,97 @@ var (
 	// On Windows: The service is limited to 32KiB while the password is limited to 2560 bytes
 	// On Linux/Unix: There is no theoretical limit but performance suffers with big values (>100KiB)
 	ErrSetDataTooBig = errors.New("data passed to Set was too big")
+	// ErrNotSupported is returned if the keyring backend does not support
+	// the requested operation, e.g. `List` on MacOS.
+	ErrNotSupported = errors.New("operation not supported by keyring backend")
+	// ErrLocked is returned if the keyring or secret is locked and couldn't
+	// be unlocked.
+	ErrLocked = errors.New("keyring is locked")
+	// ErrPromptDismissed is returned if the user dismissed a prompt of the
+	// keyring, e.g. the one to unlock it.
+	ErrPromptDismissed = errors.New("keyring prompt was dismissed")
+	// ErrAccessDenied is returned if the keyring refused access to a secret.
+	ErrAccessDenied = errors.New("access to keyring denied")
+	// ErrBackendUnavailable is returned if the keyring backend can't be used,
+	// e.g. because no Secret Service daemon is running.
+	ErrBackendUnavailable = errors.New("keyring backend unavailable")
+	// ErrBadPassphrase is returned by encrypted keyrings if the passphrase
+	// doesn't unlock them.
+	ErrBadPassphrase = errors.New("wrong passphrase for keyring")
+	// ErrTampered is returned by encrypted keyrings if a stored secret fails
+	// authentication, i.e. it was modified, corrupted or moved to another
+	// service or user, or if their key file is invalid.
+	ErrTampered = errors.New("keyring entry failed authentication")
+	// ErrInvalidName is returned if a service or user can't be stored by the
+	// backend, e.g. because it would escape the directory of the file
+	// backend.
+	ErrInvalidName = errors.New("invalid service or user name")
+	// ErrInsecurePermissions is returned by the file backends if a directory
+	// or file holding secrets is accessible by other users.
+	ErrInsecurePermissions = errors.New("keyring storage has insecure permissions")
+	// ErrQuotaExceeded is returned if a secret couldn't be stored because
+	// the user's quota of the keyring is exhausted, e.g. the key quota of the
+	// kernel keyring.
+	ErrQuotaExceeded = errors.New("keyring quota exceeded")
 )
 
+// BackendError is returned by the backends for all errors except ErrNotFound,
+// ErrSetDataTooBig, ErrNotSupported and context errors, which are returned
+// as is. Use errors.Is to check for ErrLocked, ErrPromptDismissed,
+// ErrAccessDenied and ErrBackendUnavailable.
+type BackendError struct {
+	// Backend is the registered name of the backend, e.g. "keyctl".
+	Backend string
+	// Op is the failed operation, e.g. "get".
+	Op string
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *BackendError) Error() string {
+	return "keyring: " + e.Backend + " " + e.Op + ": " + e.Err.Error()
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+// newBackendError wraps err of the given backend and operation in a
+// BackendError, see there for the errors returned as is.
+func newBackendError(backend, op string, err error) error {
+	var backendErr *BackendError
+	switch {
+	case err == nil,
+		err == ErrNotFound,
+		err == ErrSetDataTooBig,
+		err == ErrNotSupported,
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &backendErr):
+		return err
+	}
+	return &BackendError{Backend: backend, Op: op, Err: err}
+}
+
+// kindError classifies err as one of the sentinel errors of this package
+// while keeping err itself accessible to errors.Is and errors.As.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e kindError) Error() string {
+	return e.kind.Error() + ": " + e.err.Error()
+}
+
+func (e kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e kindError) Unwrap() error {
+	return e.err
+}
+
 // Keyring provides a simple set/get interface for a keyring service.
 type Keyring interface {
 	// Set password in keyring for user.
@@ -27,24 +138,311 @@ type Keyring interface {
 	Delete(service, user string) error
 	// DeleteAll deletes all secrets for a given service
 	DeleteAll(service string) error
+	// List returns the users which have a secret stored for a given service.
+	List(service string) ([]string, error)
+}
+
+// KeyringContext is implemented by keyrings whose operations can be
+// cancelled or bounded by a deadline through a context.
+type KeyringContext interface {
+	// SetContext sets password in keyring for user.
+	SetContext(ctx context.Context, service, user, password string) error
+	// GetContext gets password from keyring given service and user name.
+	GetContext(ctx context.Context, service, user string) (string, error)
+	// DeleteContext deletes secret from keyring.
+	DeleteContext(ctx context.Context, service, user string) error
+	// DeleteAllContext deletes all secrets for a given service
+	DeleteAllContext(ctx context.Context, service string) error
+	// ListContext returns the users which have a secret stored for a given
+	// service.
+	ListContext(ctx context.Context, service string) ([]string, error)
+}
+
+// BytesKeyring is implemented by keyrings which can store arbitrary binary
+// secrets. Secrets stored with SetBytes can also be read with Get.
+type BytesKeyring interface {
+	// SetBytes stores data in keyring for user.
+	SetBytes(service, user string, data []byte) error
+	// GetBytes gets data from keyring given service and user name.
+	GetBytes(service, user string) ([]byte, error)
+}
+
+// Item is a secret together with its metadata.
+type Item struct {
+	// Label is a human readable description of the secret, shown e.g. by
+	// Seahorse. If empty, SetItem uses the backend's default label.
+	Label string
+	// Attributes are additional key/value pairs stored with the secret. The
+	// names "service" and "username" are reserved.
+	Attributes map[string]string
+	// Created is the time the secret was first stored. It is ignored by
+	// SetItem and zero if the backend doesn't know it.
+	Created time.Time
+	// Modified is the time the secret was last changed. It is ignored by
+	// SetItem and zero if the backend doesn't know it.
+	Modified time.Time
+	// Expires is the time after which the secret is no longer returned, or
+	// zero if it doesn't expire.
+	Expires time.Time
+	// Secret is the secret itself.
+	Secret []byte
+}
+
+// ItemKeyring is implemented by keyrings which store metadata along with the
+// secrets. Secrets stored with SetItem can also be read with Get and GetBytes,
+// and GetItem returns the metadata of secrets stored with Set.
+type ItemKeyring interface {
+	// SetItem stores item in keyring for user.
+	SetItem(service, user string, item Item) error
+	// GetItem gets the item from keyring given service and user name.
+	GetItem(service, user string) (Item, error)
+}
+
+// Rekeyer is implemented by keyrings encrypted with a key derived from a
+// passphrase, see WithEncryptedFile.
+type Rekeyer interface {
+	// Rekey protects the keyring with a new passphrase. The stored secrets
+	// stay readable and aren't re-encrypted.
+	Rekey(passphrase []byte) error
+}
+
+// FileImporter is implemented by keyrings which can import the secrets of
+// the file backends, see WithVault.
+type FileImporter interface {
+	// ImportFiles copies the secrets of src, a keyring of the file or
+	// encrypted file backend, and returns how many were imported.
+	ImportFiles(src Keyring) (int, error)
+}
+
+// TTLKeyring is implemented by keyrings which can store secrets with a limited
+// lifetime. Once the lifetime is over, the secret is removed and reading it
+// returns ErrNotFound.
+type TTLKeyring interface {
+	// SetWithTTL sets password in keyring for user, expiring after ttl.
+	SetWithTTL(service, user, password string, ttl time.Duration) error
+}
+
+// errInvalidTTL is returned by SetWithTTL for a ttl that isn't positive.
+var errInvalidTTL = errors.New("keyring: ttl must be positive")
+
+// SetDefault replaces the keyring used by the package level functions. Passing
+// nil restores the automatically detected keyring. It is safe to call
+// concurrently with the package level functions.
+func SetDefault(k Keyring) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	provider = k
+}
+
+// defaultProvider returns the keyring used by the package level functions,
+// detecting it on first use.
+func defaultProvider() Keyring {
+	providerMu.RLock()
+	k := provider
+	providerMu.RUnlock()
+	if k != nil {
+		return k
+	}
+
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	if provider == nil {
+		k, err := detectProvider()
+		if err != nil {
+			// don't cache the failure, the environment might still change
+			return fallbackServiceProvider{err: err}
+		}
+		provider = k
+	}
+	return provider
 }
 
 // Set password in keyring for user.
 func Set(service, user, password string) error {
-	return provider.Set(service, user, password)
+	return defaultProvider().Set(service, user, password)
 }
 
 // Get password from keyring given service and user name.
 func Get(service, user string) (string, error) {
-	return provider.Get(service, user)
+	return defaultProvider().Get(service, user)
 }
 
 // Delete secret from keyring.
 func Delete(service, user string) error {
-	return provider.Delete(service, user)
+	return defaultProvider().Delete(service, user)
 }
 
 // DeleteAll deletes all secrets for a given service
 func DeleteAll(service string) error {
-	return provider.DeleteAll(service)
+	return defaultProvider().DeleteAll(service)
+}
+
+// List returns the users which have a secret stored for a given service.
+func List(service string) ([]string, error) {
+	return defaultProvider().List(service)
+}
+
+// SetBytes stores data in keyring for user. Unlike Set, the data is
+// returned unaltered by GetBytes on every platform.
+func SetBytes(service, user string, data []byte) error {
+	return setBytes(defaultProvider(), service, user, data)
+}
+
+// GetBytes gets data from keyring given service and user name.
+func GetBytes(service, user string) ([]byte, error) {
+	return getBytes(defaultProvider(), service, user)
+}
+
+// SetWithTTL sets password in keyring for user. Once ttl has passed, the
+// secret is removed and Get returns ErrNotFound. It returns ErrNotSupported if
+// the keyring can't expire secrets.
+func SetWithTTL(service, user, password string, ttl time.Duration) error {
+	return setWithTTL(defaultProvider(), service, user, password, ttl)
+}
+
+// SetItem stores item in keyring for user, replacing any existing secret.
+// It returns ErrNotSupported if the keyring can't store metadata.
+func SetItem(service, user string, item Item) error {
+	return setItem(defaultProvider(), service, user, item)
+}
+
+// GetItem gets the item from keyring given service and user name. It returns
+// ErrNotSupported if the keyring can't store metadata.
+func GetItem(service, user string) (Item, error) {
+	return getItem(defaultProvider(), service, user)
+}
+
+// SetContext sets password in keyring for user. It returns ctx.Err() if ctx
+// is done before the operation completes.
+func SetContext(ctx context.Context, service, user, password string) error {
+	return setContext(ctx, defaultProvider(), service, user, password)
+}
+
+// GetContext gets password from keyring given service and user name. It
+// returns ctx.Err() if ctx is done before the operation completes.
+func GetContext(ctx context.Context, service, user string) (string, error) {
+	return getContext(ctx, defaultProvider(), service, user)
+}
+
+// DeleteContext deletes secret from keyring. It returns ctx.Err() if ctx is
+// done before the operation completes.
+func DeleteContext(ctx context.Context, service, user string) error {
+	return deleteContext(ctx, defaultProvider(), service, user)
+}
+
+// DeleteAllContext deletes all secrets for a given service. It returns
+// ctx.Err() if ctx is done before the operation completes.
+func DeleteAllContext(ctx context.Context, service string) error {
+	return deleteAllContext(ctx, defaultProvider(), service)
+}
+
+// ListContext returns the users which have a secret stored for a given
+// service. It returns ctx.Err() if ctx is done before the operation completes.
+func ListContext(ctx context.Context, service string) ([]string, error) {
+	return listContext(ctx, defaultProvider(), service)
+}
+
+// setContext calls k.SetContext if k implements KeyringContext and falls back
+// to checking ctx before calling k.Set otherwise. The other *Context helpers
+// below follow the same pattern.
+func setContext(ctx context.Context, k Keyring, service, user, password string) error {
+	if kc, ok := k.(KeyringContext); ok {
+		return kc.SetContext(ctx, service, user, password)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return k.Set(service, user, password)
+}
+
+func getContext(ctx context.Context, k Keyring, service, user string) (string, error) {
+	if kc, ok := k.(KeyringContext); ok {
+		return kc.GetContext(ctx, service, user)
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return k.Get(service, user)
+}
+
+func deleteContext(ctx context.Context, k Keyring, service, user string) error {
+	if kc, ok := k.(KeyringContext); ok {
+		return kc.DeleteContext(ctx, service, user)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return k.Delete(service, user)
+}
+
+func deleteAllContext(ctx context.Context, k Keyring, service string) error {
+	if kc, ok := k.(KeyringContext); ok {
+		return kc.DeleteAllContext(ctx, service)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return k.DeleteAll(service)
+}
+
+func listContext(ctx context.Context, k Keyring, service string) ([]string, error) {
+	if kc, ok := k.(KeyringContext); ok {
+		return kc.ListContext(ctx, service)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return k.List(service)
+}
+
+// setBytes calls k.SetBytes if k implements BytesKeyring and stores data as
+// string otherwise.
+func setBytes(k Keyring, service, user string, data []byte) error {
+	if bk, ok := k.(BytesKeyring); ok {
+		return bk.SetBytes(service, user, data)
+	}
+	return k.Set(service, user, string(data))
+}
+
+// getBytes calls k.GetBytes if k implements BytesKeyring and reads the
+// secret as string otherwise.
+func getBytes(k Keyring, service, user string) ([]byte, error) {
+	if bk, ok := k.(BytesKeyring); ok {
+		return bk.GetBytes(service, user)
+	}
+	secret, err := k.Get(service, user)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(secret), nil
+}
+
+// setItem calls k.SetItem if k implements ItemKeyring and returns
+// ErrNotSupported otherwise.
+func setItem(k Keyring, service, user string, item Item) error {
+	if ik, ok := k.(ItemKeyring); ok {
+		return ik.SetItem(service, user, item)
+	}
+	return ErrNotSupported
+}
+
+// getItem calls k.GetItem if k implements ItemKeyring and returns
+// ErrNotSupported otherwise.
+func getItem(k Keyring, service, user string) (Item, error) {
+	if ik, ok := k.(ItemKeyring); ok {
+		return ik.GetItem(service, user)
+	}
+	return Item{}, ErrNotSupported
+}
+
+// setWithTTL calls k.SetWithTTL if k implements TTLKeyring and returns
+// ErrNotSupported otherwise.
+func setWithTTL(k Keyring, service, user, password string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return errInvalidTTL
+	}
+	if tk, ok := k.(TTLKeyring); ok {
+		return tk.SetWithTTL(service, user, password, ttl)
+	}
+	return ErrNotSupported
 }
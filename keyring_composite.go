@@ -1,40 +1,184 @@
-//go:build (dragonfly && cgo) || (freebsd && cgo) || linux || netbsd || openbsd
-
 package keyring
 
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
 type compositeProvider struct {
 	primary  Keyring
 	fallback Keyring
 }
 
+// useFallback reports whether the fallback should be tried after the primary
+// keyring failed with err. Backends which aren't usable when the keyring is
+// opened are skipped by openPreferred, so this only covers a primary becoming
+// unavailable afterwards, e.g. because the Secret Service daemon exited. Any
+// other error, e.g. a locked keyring, a dismissed prompt or an exhausted
+// quota, is passed on rather than silently storing the secret in the
+// fallback, which is usually less secure, e.g. plaintext files.
+func (c compositeProvider) useFallback(ctx context.Context, err error) bool {
+	return err != nil && c.fallback != nil && ctx.Err() == nil && errors.Is(err, ErrBackendUnavailable)
+}
+
+// readFallback reports whether the fallback should be read after the primary
+// keyring failed with err. Besides an unavailable primary, this covers
+// secrets only the fallback holds, e.g. ones stored by older versions, which
+// fell back on any error.
+func (c compositeProvider) readFallback(ctx context.Context, err error) bool {
+	return c.useFallback(ctx, err) || c.fallback != nil && ctx.Err() == nil && errors.Is(err, ErrNotFound)
+}
+
+// alsoFallback reports whether a deletion or listing which ended with err in
+// the primary keyring has to cover the fallback as well, so that secrets only
+// the fallback holds are listed, and don't reappear once they are deleted
+// from the primary keyring.
+func (c compositeProvider) alsoFallback(ctx context.Context, err error) bool {
+	return c.fallback != nil && ctx.Err() == nil && (err == nil || errors.Is(err, ErrNotFound))
+}
+
+// fallbackError combines the results of a deletion in the primary keyring and
+// the fallback. The fallback lacking the secret or being unusable doesn't
+// matter if the primary keyring held it.
+func fallbackError(err, fallbackErr error) error {
+	if err == nil && (errors.Is(fallbackErr, ErrNotFound) || errors.Is(fallbackErr, ErrBackendUnavailable) || errors.Is(fallbackErr, ErrNotSupported)) {
+		return nil
+	}
+	return fallbackErr
+}
+
 func (c compositeProvider) Set(service, user, pass string) error {
-	err := c.primary.Set(service, user, pass)
-	if err != nil && c.fallback != nil {
-		return c.fallback.Set(service, user, pass)
+	return c.SetContext(context.Background(), service, user, pass)
+}
+
+func (c compositeProvider) SetContext(ctx context.Context, service, user, pass string) error {
+	err := setContext(ctx, c.primary, service, user, pass)
+	if c.useFallback(ctx, err) {
+		return setContext(ctx, c.fallback, service, user, pass)
+	}
+	return err
+}
+
+func (c compositeProvider) SetBytes(service, user string, data []byte) error {
+	err := setBytes(c.primary, service, user, data)
+	if c.useFallback(context.Background(), err) {
+		return setBytes(c.fallback, service, user, data)
+	}
+	return err
+}
+
+func (c compositeProvider) SetWithTTL(service, user, pass string, ttl time.Duration) error {
+	err := setWithTTL(c.primary, service, user, pass, ttl)
+	if c.useFallback(context.Background(), err) {
+		return setWithTTL(c.fallback, service, user, pass, ttl)
+	}
+	return err
+}
+
+func (c compositeProvider) SetItem(service, user string, item Item) error {
+	err := setItem(c.primary, service, user, item)
+	if c.useFallback(context.Background(), err) {
+		return setItem(c.fallback, service, user, item)
 	}
 	return err
 }
 
 func (c compositeProvider) Get(service, user string) (string, error) {
-	result, err := c.primary.Get(service, user)
-	if err != nil && c.fallback != nil {
-		return c.fallback.Get(service, user)
+	return c.GetContext(context.Background(), service, user)
+}
+
+func (c compositeProvider) GetContext(ctx context.Context, service, user string) (string, error) {
+	result, err := getContext(ctx, c.primary, service, user)
+	if c.readFallback(ctx, err) {
+		return getContext(ctx, c.fallback, service, user)
 	}
 	return result, err
 }
 
+func (c compositeProvider) GetBytes(service, user string) ([]byte, error) {
+	result, err := getBytes(c.primary, service, user)
+	if c.readFallback(context.Background(), err) {
+		return getBytes(c.fallback, service, user)
+	}
+	return result, err
+}
+
+func (c compositeProvider) GetItem(service, user string) (Item, error) {
+	item, err := getItem(c.primary, service, user)
+	if c.readFallback(context.Background(), err) {
+		return getItem(c.fallback, service, user)
+	}
+	return item, err
+}
+
 func (c compositeProvider) Delete(service, user string) error {
-	err := c.primary.Delete(service, user)
-	if err != nil && c.fallback != nil {
-		return c.fallback.Delete(service, user)
+	return c.DeleteContext(context.Background(), service, user)
+}
+
+func (c compositeProvider) DeleteContext(ctx context.Context, service, user string) error {
+	err := deleteContext(ctx, c.primary, service, user)
+	if c.useFallback(ctx, err) {
+		return deleteContext(ctx, c.fallback, service, user)
+	}
+	if c.alsoFallback(ctx, err) {
+		return fallbackError(err, deleteContext(ctx, c.fallback, service, user))
 	}
 	return err
 }
 
 func (c compositeProvider) DeleteAll(service string) error {
-	err := c.primary.DeleteAll(service)
-	if err != nil && c.fallback != nil {
-		return c.fallback.DeleteAll(service)
+	return c.DeleteAllContext(context.Background(), service)
+}
+
+func (c compositeProvider) DeleteAllContext(ctx context.Context, service string) error {
+	err := deleteAllContext(ctx, c.primary, service)
+	if c.useFallback(ctx, err) {
+		return deleteAllContext(ctx, c.fallback, service)
+	}
+	if c.alsoFallback(ctx, err) {
+		return fallbackError(err, deleteAllContext(ctx, c.fallback, service))
 	}
 	return err
 }
+
+func (c compositeProvider) List(service string) ([]string, error) {
+	return c.ListContext(context.Background(), service)
+}
+
+func (c compositeProvider) ListContext(ctx context.Context, service string) ([]string, error) {
+	users, err := listContext(ctx, c.primary, service)
+	if c.useFallback(ctx, err) {
+		return listContext(ctx, c.fallback, service)
+	}
+	if err != nil || !c.alsoFallback(ctx, err) {
+		return users, err
+	}
+
+	fallbackUsers, err := listContext(ctx, c.fallback, service)
+	if err != nil {
+		if err = fallbackError(nil, err); err != nil {
+			return nil, err
+		}
+		return users, nil
+	}
+	return mergeUsers(users, fallbackUsers), nil
+}
+
+// mergeUsers returns the sorted union of the users of the primary keyring
+// and the fallback.
+func mergeUsers(users, fallbackUsers []string) []string {
+	seen := make(map[string]bool, len(users))
+	for _, user := range users {
+		seen[user] = true
+	}
+	for _, user := range fallbackUsers {
+		if !seen[user] {
+			seen[user] = true
+			users = append(users, user)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
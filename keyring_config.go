@@ -0,0 +1,130 @@
+package keyring
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// BackendEnv is the environment variable holding a comma separated list
+	// of the backends the auto-detection may use, in order of preference,
+	// e.g. "keyctl,secret-service". It takes precedence over the config file.
+	BackendEnv = "GO_KEYRING_BACKEND"
+
+	// configFile is the path of the optional config file relative to the
+	// user's config directory, i.e. $XDG_CONFIG_HOME on Linux.
+	configFile = "go-keyring/config"
+)
+
+// detectConfig holds the settings of the auto-detection.
+type detectConfig struct {
+	// backends allowed in order of preference, nil if not restricted.
+	backends []string
+	// configs passed to the backends by name.
+	configs map[string]Config
+}
+
+// loadDetectConfig reads the settings of the auto-detection from the
+// environment and the config file.
+//
+// The config file consists of "key = value" lines, empty lines and comments
+// starting with "#". The "backends" key takes a comma separated list like
+// BackendEnv, keys of the form "<backend>.<option>" are passed to the backend
+// in Config.Options, e.g.:
+//
+//	# never store plaintext secrets on this host
+//	backends = keyctl, secret-service
+func loadDetectConfig() (detectConfig, error) {
+	cfg := detectConfig{configs: map[string]Config{}}
+
+	if dir, err := os.UserConfigDir(); err == nil {
+		path := filepath.Join(dir, filepath.FromSlash(configFile))
+		// older versions of the file backend stored the secrets of a service
+		// named "config" in a directory at that path, which is ignored
+		f, err := openRegular(path)
+		if err != nil && !os.IsNotExist(err) {
+			return detectConfig{}, fmt.Errorf("keyring: failed to read config file: %w", err)
+		}
+		if f != nil {
+			cfg, err = parseDetectConfig(f)
+			f.Close()
+			if err != nil {
+				return detectConfig{}, fmt.Errorf("keyring: failed to parse %s: %w", path, err)
+			}
+		}
+	}
+
+	if backends := splitBackends(os.Getenv(BackendEnv)); backends != nil {
+		cfg.backends = backends
+	}
+
+	return cfg, nil
+}
+
+// openRegular opens the file at path, returning nil if it isn't a regular
+// file.
+func openRegular(path string) (*os.File, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, nil
+	}
+	return os.Open(path)
+}
+
+// parseDetectConfig parses the config file format described at
+// loadDetectConfig.
+func parseDetectConfig(r io.Reader) (detectConfig, error) {
+	cfg := detectConfig{configs: map[string]Config{}}
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return detectConfig{}, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if key == "backends" {
+			cfg.backends = splitBackends(value)
+			continue
+		}
+
+		backend, option, ok := strings.Cut(key, ".")
+		if !ok || backend == "" || option == "" {
+			return detectConfig{}, fmt.Errorf("line %d: unknown key %q", lineNo, key)
+		}
+		c := cfg.configs[backend]
+		if c.Options == nil {
+			c.Options = map[string]string{}
+		}
+		c.Options[option] = value
+		cfg.configs[backend] = c
+	}
+
+	return cfg, scanner.Err()
+}
+
+// splitBackends splits a comma separated list of backends, returning nil if
+// it is empty.
+func splitBackends(list string) []string {
+	var backends []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			backends = append(backends, name)
+		}
+	}
+	return backends
+}
@@ -0,0 +1,55 @@
+package keyring
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestParseDetectConfig tests parsing the config file.
+func TestParseDetectConfig(t *testing.T) {
+	cfg, err := parseDetectConfig(strings.NewReader(`
+# never store plaintext secrets on this host
+backends = keyctl, secret-service
+
+keyctl.keyring = user
+`))
+	if err != nil {
+		t.Fatalf("Should not fail, got: %s", err)
+	}
+
+	if len(cfg.backends) != 2 || cfg.backends[0] != "keyctl" || cfg.backends[1] != "secret-service" {
+		t.Errorf("Expected backends [keyctl secret-service], got %v", cfg.backends)
+	}
+
+	if cfg.configs["keyctl"].Options["keyring"] != "user" {
+		t.Errorf("Expected keyctl option keyring=user, got %v", cfg.configs)
+	}
+
+	_, err = parseDetectConfig(strings.NewReader("backends"))
+	if err == nil {
+		t.Errorf("Expected error for line without value")
+	}
+}
+
+// TestBackendEnv tests restricting the auto-detection through BackendEnv.
+func TestBackendEnv(t *testing.T) {
+	Register("test-env-unusable", func(Config) (Keyring, error) {
+		return nil, errors.New("unusable")
+	})
+
+	t.Setenv(BackendEnv, "test-env-unusable, mock")
+	k, err := New(WithAutoDetect())
+	if err != nil {
+		t.Fatalf("Should not fail, got: %s", err)
+	}
+	if _, ok := k.(*mockProvider); !ok {
+		t.Errorf("Expected mock keyring, got %T", k)
+	}
+
+	t.Setenv(BackendEnv, "test-env-unusable")
+	_, err = New(WithAutoDetect())
+	if err == nil || !strings.Contains(err.Error(), "unusable") {
+		t.Errorf("Expected error naming the unusable backend, got %v", err)
+	}
+}
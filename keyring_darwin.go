@@ -15,6 +15,7 @@
 package keyring
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
@@ -41,39 +42,71 @@ type macOSXKeychain struct{}
 
 // Get password from macos keyring given service and user name.
 func (k macOSXKeychain) Get(service, username string) (string, error) {
-	out, err := exec.Command(
+	return k.GetContext(context.Background(), service, username)
+}
+
+// GetContext gets password from macos keyring given service and user name.
+func (k macOSXKeychain) GetContext(ctx context.Context, service, username string) (string, error) {
+	data, err := k.get(ctx, service, username)
+	if err != nil {
+		return "", newBackendError("keychain", "get", err)
+	}
+
+	return string(data), nil
+}
+
+// GetBytes gets data from macos keyring given service and user name.
+func (k macOSXKeychain) GetBytes(service, username string) ([]byte, error) {
+	data, err := k.get(context.Background(), service, username)
+	return data, newBackendError("keychain", "get", err)
+}
+
+// get reads and decodes a secret from the macos keyring.
+func (k macOSXKeychain) get(ctx context.Context, service, username string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx,
 		execPathKeychain,
 		"find-generic-password",
 		"-s", service,
 		"-wa", username).CombinedOutput()
 	if err != nil {
-		if strings.Contains(string(out), "could not be found") {
-			err = ErrNotFound
-		}
-		return "", err
+		return nil, keychainError(out, err)
 	}
 
 	trimStr := strings.TrimSpace(string(out[:]))
 	// if the string has the well-known prefix, assume it's encoded
 	if strings.HasPrefix(trimStr, encodingPrefix) {
-		dec, err := hex.DecodeString(trimStr[len(encodingPrefix):])
-		return string(dec), err
+		return hex.DecodeString(trimStr[len(encodingPrefix):])
 	} else if strings.HasPrefix(trimStr, base64EncodingPrefix) {
-		dec, err := base64.StdEncoding.DecodeString(trimStr[len(base64EncodingPrefix):])
-		return string(dec), err
+		return base64.StdEncoding.DecodeString(trimStr[len(base64EncodingPrefix):])
 	}
 
-	return trimStr, nil
+	return []byte(trimStr), nil
 }
 
 // Set stores a secret in the macos keyring given a service name and a user.
 func (k macOSXKeychain) Set(service, username, password string) error {
+	return k.SetContext(context.Background(), service, username, password)
+}
+
+// SetContext stores a secret in the macos keyring given a service name and a
+// user.
+func (k macOSXKeychain) SetContext(ctx context.Context, service, username, password string) error {
+	return newBackendError("keychain", "set", k.set(ctx, service, username, []byte(password)))
+}
+
+// SetBytes stores data in the macos keyring given a service name and a user.
+func (k macOSXKeychain) SetBytes(service, username string, data []byte) error {
+	return newBackendError("keychain", "set", k.set(context.Background(), service, username, data))
+}
+
+// set encodes and stores a secret in the macos keyring.
+func (k macOSXKeychain) set(ctx context.Context, service, username string, data []byte) error {
 	// if the added secret has multiple lines or some non ascii,
 	// osx will hex encode it on return. To avoid getting garbage, we
 	// encode all passwords
-	password = base64EncodingPrefix + base64.StdEncoding.EncodeToString([]byte(password))
+	password := base64EncodingPrefix + base64.StdEncoding.EncodeToString(data)
 
-	cmd := exec.Command(execPathKeychain, "-i")
+	cmd := exec.CommandContext(ctx, execPathKeychain, "-i")
 	stdIn, err := cmd.StdinPipe()
 	if err != nil {
 		return err
@@ -102,19 +135,27 @@ func (k macOSXKeychain) Set(service, username, password string) error {
 
 // Delete deletes a secret, identified by service & user, from the keyring.
 func (k macOSXKeychain) Delete(service, username string) error {
-	out, err := exec.Command(
+	return k.DeleteContext(context.Background(), service, username)
+}
+
+// DeleteContext deletes a secret, identified by service & user, from the
+// keyring.
+func (k macOSXKeychain) DeleteContext(ctx context.Context, service, username string) error {
+	out, err := exec.CommandContext(ctx,
 		execPathKeychain,
 		"delete-generic-password",
 		"-s", service,
 		"-a", username).CombinedOutput()
-	if strings.Contains(string(out), "could not be found") {
-		err = ErrNotFound
-	}
-	return err
+	return newBackendError("keychain", "delete", keychainError(out, err))
 }
 
 // DeleteAll deletes all secrets for a given service
 func (k macOSXKeychain) DeleteAll(service string) error {
+	return k.DeleteAllContext(context.Background(), service)
+}
+
+// DeleteAllContext deletes all secrets for a given service
+func (k macOSXKeychain) DeleteAllContext(ctx context.Context, service string) error {
 	// if service is empty, do nothing otherwise it might accidentally delete all secrets
 	if service == "" {
 		return ErrNotFound
@@ -122,19 +163,49 @@ func (k macOSXKeychain) DeleteAll(service string) error {
 	// Delete each secret in a while loop until there is no more left
 	// under the service
 	for {
-		out, err := exec.Command(
+		out, err := exec.CommandContext(ctx,
 			execPathKeychain,
 			"delete-generic-password",
 			"-s", service).CombinedOutput()
 		if strings.Contains(string(out), "could not be found") {
 			return nil
 		} else if err != nil {
-			return err
+			return newBackendError("keychain", "delete all", keychainError(out, err))
 		}
 	}
 
 }
 
+// List is not supported as the security binary offers no way to
+// enumerate the accounts of a service.
+func (k macOSXKeychain) List(service string) ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+// ListContext is not supported, see List.
+func (k macOSXKeychain) ListContext(ctx context.Context, service string) ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+// keychainError maps the output of a failed security command onto the errors
+// of this package.
+func keychainError(out []byte, err error) error {
+	switch {
+	case strings.Contains(string(out), "could not be found"):
+		return ErrNotFound
+	case err == nil:
+		return nil
+	case strings.Contains(string(out), "User canceled the operation"):
+		return kindError{ErrPromptDismissed, err}
+	case strings.Contains(string(out), "User interaction is not allowed"):
+		return kindError{ErrLocked, err}
+	}
+	return err
+}
+
 func init() {
-	provider = macOSXKeychain{}
+	Register("keychain", func(Config) (Keyring, error) {
+		return macOSXKeychain{}, nil
+	})
+	backendPreference = []string{"keychain"}
 }
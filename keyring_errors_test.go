@@ -0,0 +1,148 @@
+package keyring
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestBackendError tests wrapping and classifying backend errors.
+func TestBackendError(t *testing.T) {
+	cause := errors.New("cause")
+	err := newBackendError("test", "get", kindError{ErrLocked, cause})
+
+	var backendErr *BackendError
+	if !errors.As(err, &backendErr) {
+		t.Fatalf("Expected *BackendError, got %T", err)
+	}
+	if backendErr.Backend != "test" || backendErr.Op != "get" {
+		t.Errorf("Expected backend test and op get, got %s and %s", backendErr.Backend, backendErr.Op)
+	}
+	if !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected error to be ErrLocked, got %s", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Errorf("Expected error to wrap its cause, got %s", err)
+	}
+	if errors.Is(err, ErrAccessDenied) {
+		t.Errorf("Expected error not to be ErrAccessDenied, got %s", err)
+	}
+
+	for _, err := range []error{nil, ErrNotFound, ErrSetDataTooBig, ErrNotSupported, context.Canceled, backendErr} {
+		if got := newBackendError("test", "get", err); got != err {
+			t.Errorf("Expected %v to be returned as is, got %v", err, got)
+		}
+	}
+}
+
+// TestCompositeDismissed tests that a dismissed prompt doesn't fall back.
+func TestCompositeDismissed(t *testing.T) {
+	dismissed := newBackendError("test", "set", kindError{ErrPromptDismissed, errors.New("dismissed")})
+	fallback := &mockProvider{}
+	c := compositeProvider{primary: &mockProvider{mockError: dismissed}, fallback: fallback}
+
+	err := c.Set(service, user, password)
+	if !errors.Is(err, ErrPromptDismissed) {
+		t.Errorf("Expected ErrPromptDismissed, got %v", err)
+	}
+
+	_, err = fallback.Get(service, user)
+	assertError(t, err, ErrNotFound)
+}
+
+// TestCompositeQuotaExceeded tests that an exhausted quota doesn't fall back.
+func TestCompositeQuotaExceeded(t *testing.T) {
+	exceeded := newBackendError("test", "set", kindError{ErrQuotaExceeded, errors.New("quota")})
+	fallback := &mockProvider{}
+	c := compositeProvider{primary: &mockProvider{mockError: exceeded}, fallback: fallback}
+
+	err := c.Set(service, user, password)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+	}
+
+	_, err = fallback.Get(service, user)
+	assertError(t, err, ErrNotFound)
+}
+
+// TestCompositeLocked tests that a locked primary doesn't fall back.
+func TestCompositeLocked(t *testing.T) {
+	locked := newBackendError("test", "set", kindError{ErrLocked, errors.New("locked")})
+	fallback := &mockProvider{}
+	c := compositeProvider{primary: &mockProvider{mockError: locked}, fallback: fallback}
+
+	err := c.Set(service, user, password)
+	if !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked, got %v", err)
+	}
+
+	_, err = fallback.Get(service, user)
+	assertError(t, err, ErrNotFound)
+}
+
+// TestCompositeUnavailable tests that an unavailable primary falls back.
+func TestCompositeUnavailable(t *testing.T) {
+	unavailable := newBackendError("test", "set", kindError{ErrBackendUnavailable, errors.New("gone")})
+	fallback := &mockProvider{}
+	c := compositeProvider{primary: &mockProvider{mockError: unavailable}, fallback: fallback}
+
+	if err := c.Set(service, user, password); err != nil {
+		t.Fatalf("Expected fallback to be used, got %v", err)
+	}
+
+	pw, err := fallback.Get(service, user)
+	if err != nil || pw != password {
+		t.Errorf("Expected password %s in fallback, got %q and %v", password, pw, err)
+	}
+}
+
+// TestCompositeFallbackOnly tests that secrets only the fallback holds are
+// still read, listed and deleted.
+func TestCompositeFallbackOnly(t *testing.T) {
+	primary := &mockProvider{}
+	fallback := &mockProvider{}
+	c := compositeProvider{primary: primary, fallback: fallback}
+
+	if err := fallback.Set(service, user, password); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.Set(service, user+"2", password); err != nil {
+		t.Fatal(err)
+	}
+	// a stale copy, which must not reappear once deleted from the primary
+	if err := fallback.Set(service, user+"2", password+"-old"); err != nil {
+		t.Fatal(err)
+	}
+
+	if pw, err := c.Get(service, user); err != nil || pw != password {
+		t.Errorf("Expected password %s from fallback, got %q and %v", password, pw, err)
+	}
+	if data, err := c.GetBytes(service, user); err != nil || string(data) != password {
+		t.Errorf("Expected password %s from fallback, got %q and %v", password, data, err)
+	}
+	if item, err := c.GetItem(service, user); err != nil || string(item.Secret) != password {
+		t.Errorf("Expected password %s from fallback, got %q and %v", password, item.Secret, err)
+	}
+	if pw, err := c.Get(service, user+"2"); err != nil || pw != password {
+		t.Errorf("Expected password %s from primary, got %q and %v", password, pw, err)
+	}
+
+	users, err := c.List(service)
+	if err != nil || len(users) != 2 || users[0] != user || users[1] != user+"2" {
+		t.Errorf("Expected users of both keyrings, got %v and %v", users, err)
+	}
+
+	if err := c.Delete(service, user); err != nil {
+		t.Errorf("Failed to delete password of fallback: %v", err)
+	}
+	_, err = c.Get(service, user)
+	assertError(t, err, ErrNotFound)
+
+	if err := c.Delete(service, user+"2"); err != nil {
+		t.Errorf("Failed to delete password: %v", err)
+	}
+	_, err = c.Get(service, user+"2")
+	assertError(t, err, ErrNotFound)
+
+	assertError(t, c.Delete(service, user), ErrNotFound)
+}
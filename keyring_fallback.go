@@ -3,25 +3,61 @@ package keyring
 import (
 	"errors"
 	"runtime"
+	"time"
 )
 
 // All of the following methods error out on unsupported platforms
 var ErrUnsupportedPlatform = errors.New("unsupported platform: " + runtime.GOOS)
 
-type fallbackServiceProvider struct{}
+// fallbackServiceProvider fails all operations with err, or with
+// ErrUnsupportedPlatform if err is nil.
+type fallbackServiceProvider struct {
+	err error
+}
 
-func (fallbackServiceProvider) Set(service, user, pass string) error {
+func (f fallbackServiceProvider) error() error {
+	if f.err != nil {
+		return f.err
+	}
 	return ErrUnsupportedPlatform
 }
 
-func (fallbackServiceProvider) Get(service, user string) (string, error) {
-	return "", ErrUnsupportedPlatform
+func (f fallbackServiceProvider) Set(service, user, pass string) error {
+	return f.error()
 }
 
-func (fallbackServiceProvider) Delete(service, user string) error {
-	return ErrUnsupportedPlatform
+func (f fallbackServiceProvider) SetBytes(service, user string, data []byte) error {
+	return f.error()
 }
 
-func (fallbackServiceProvider) DeleteAll(service string) error {
-	return ErrUnsupportedPlatform
+func (f fallbackServiceProvider) Get(service, user string) (string, error) {
+	return "", f.error()
+}
+
+func (f fallbackServiceProvider) GetBytes(service, user string) ([]byte, error) {
+	return nil, f.error()
+}
+
+func (f fallbackServiceProvider) SetWithTTL(service, user, pass string, ttl time.Duration) error {
+	return f.error()
+}
+
+func (f fallbackServiceProvider) SetItem(service, user string, item Item) error {
+	return f.error()
+}
+
+func (f fallbackServiceProvider) GetItem(service, user string) (Item, error) {
+	return Item{}, f.error()
+}
+
+func (f fallbackServiceProvider) Delete(service, user string) error {
+	return f.error()
+}
+
+func (f fallbackServiceProvider) DeleteAll(service string) error {
+	return f.error()
+}
+
+func (f fallbackServiceProvider) List(service string) ([]string, error) {
+	return nil, f.error()
 }
@@ -3,87 +3,566 @@
 package keyring
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	osuser "os/user"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
 )
 
-type fileProvider struct{}
+// FileDirEnv is the environment variable holding the directory below which
+// the file backends store secrets, see WithFileDir.
+const FileDirEnv = "GO_KEYRING_FILE_DIR"
+
+type fileProvider struct {
+	// dir is the directory below which secrets are stored, see WithFileDir.
+	dir string
+	// repair makes permission checks tighten loose permissions instead of
+	// failing, see WithFilePermissionRepair.
+	repair bool
+	// crypter encrypts the files if set, see WithEncryptedFile.
+	crypter *fileCrypter
+	// vault is set for the root of the vault backend, see WithVault.
+	vault bool
+	// owner is the user owning the files if they aren't the caller's, see
+	// WithUID.
+	owner *fileOwner
+}
+
+// fileOwner is a user owning the files of a file backend, see WithUID.
+type fileOwner struct {
+	uid  int
+	gid  int
+	home string
+}
+
+// newFileOwner looks up the user with the given uid, checking that the
+// caller may manage their files.
+func newFileOwner(uid int) (*fileOwner, error) {
+	u, err := osuser.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up uid %d: %w", uid, err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid of uid %d: %w", uid, err)
+	}
+
+	if uid != os.Geteuid() && !hasCapabilities(unix.CAP_CHOWN, unix.CAP_DAC_OVERRIDE) {
+		return nil, kindError{ErrAccessDenied, fmt.Errorf("managing the files of uid %d requires CAP_CHOWN and CAP_DAC_OVERRIDE", uid)}
+	}
+
+	return &fileOwner{uid: uid, gid: gid, home: u.HomeDir}, nil
+}
+
+// chown gives the file at path to the owner. It does nothing if o is nil.
+func (o *fileOwner) chown(path string) error {
+	if o == nil {
+		return nil
+	}
+	if err := os.Lchown(path, o.uid, o.gid); err != nil {
+		return fmt.Errorf("failed to give %s to uid %d: %w", path, o.uid, err)
+	}
+	return nil
+}
+
+// setOwner makes the files belong to owner, see WithUID.
+func (f *fileProvider) setOwner(owner *fileOwner) error {
+	f.owner = owner
+	if f.crypter == nil {
+		return nil
+	}
+	f.crypter.owner = owner
+	// keep the key in the kernel keyring of the owner as well
+	if c, ok := f.crypter.cache.(keyctlKeyCache); ok {
+		keyctl, err := newKeyctlProvider(c.keyctl.keyring, c.keyctl.name, c.keyctl.perm, &owner.uid)
+		if err != nil {
+			return err
+		}
+		f.crypter.cache = keyctlKeyCache{keyctl}
+	}
+	return nil
+}
 
 func init() {
-	originalFallback := getFallbackProvider
-	getFallbackProvider = func() Keyring {
-		fallback := originalFallback()
-		if fallback != nil {
-			return fallback
+	Register("file", func(cfg Config) (Keyring, error) {
+		f := &fileProvider{}
+		if err := f.configure(cfg); err != nil {
+			return nil, f.error("open", err)
+		}
+		return f, nil
+	})
+}
+
+// WithFile selects the backend storing secrets as plaintext files, see
+// WithFileDir for their location.
+func WithFile() Option {
+	return func(o *options) {
+		o.open = func() (Keyring, error) {
+			return o.newFileProvider(nil)
 		}
-		return &fileProvider{}
 	}
 }
 
+// WithFileDir sets the directory below which the file backends store
+// secrets, in the subdirectories go-keyring and go-keyring-encrypted. It
+// defaults to the environment variable FileDirEnv and otherwise to
+// $XDG_STATE_HOME, i.e. ~/.local/state. Secrets stored by older versions
+// below the config directory are still used from there.
+//
+// It only applies together with WithFile, WithEncryptedFile or
+// WithHybridFile. Backends opened by name take the "dir" option instead.
+func WithFileDir(dir string) Option {
+	return func(o *options) {
+		o.fileDir = dir
+	}
+}
+
+// WithFilePermissionRepair makes the file backends tighten the permissions
+// of the user's own directories and files to 0700 and 0600, instead of
+// failing with ErrInsecurePermissions. Files owned by other users are
+// refused in any case. Backends opened by name take the
+// "repair-permissions" option instead.
+func WithFilePermissionRepair() Option {
+	return func(o *options) {
+		o.repairFilePerm = true
+	}
+}
+
+// newFileProvider returns a file backend using crypter, configured by the
+// file options.
+func (o *options) newFileProvider(crypter *fileCrypter) (*fileProvider, error) {
+	f := &fileProvider{dir: o.fileDir, repair: o.repairFilePerm, crypter: crypter}
+	if o.uid != nil {
+		owner, err := newFileOwner(*o.uid)
+		if err != nil {
+			return nil, f.error("open", err)
+		}
+		if err := f.setOwner(owner); err != nil {
+			return nil, f.error("open", err)
+		}
+	}
+	return f, nil
+}
+
+// configure applies the "dir", "repair-permissions" and "uid" options of cfg
+// and checks that the backend is usable.
+func (f *fileProvider) configure(cfg Config) error {
+	f.dir = cfg.Options["dir"]
+
+	if value := cfg.Options["uid"]; value != "" {
+		uid, err := lookupUID(value)
+		if err != nil {
+			return err
+		}
+		owner, err := newFileOwner(uid)
+		if err != nil {
+			return err
+		}
+		if err := f.setOwner(owner); err != nil {
+			return err
+		}
+	}
+
+	if value, ok := cfg.Options["repair-permissions"]; ok {
+		repair, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid repair-permissions option: %w", err)
+		}
+		f.repair = repair
+	}
+
+	if _, err := f.root(); err != nil {
+		return kindError{ErrBackendUnavailable, err}
+	}
+	return nil
+}
+
 func (f *fileProvider) Set(service, user, password string) error {
-	tokenPath, err := getTokenFilePath(service, user)
+	return f.SetContext(context.Background(), service, user, password)
+}
+
+func (f *fileProvider) SetContext(ctx context.Context, service, user, password string) error {
+	return f.error("set", f.set(ctx, service, user, []byte(password), nil, time.Time{}))
+}
+
+func (f *fileProvider) SetBytes(service, user string, data []byte) error {
+	return f.error("set", f.set(context.Background(), service, user, data, nil, time.Time{}))
+}
+
+// SetWithTTL stores the secret together with its expiry, which is enforced
+// when reading it.
+func (f *fileProvider) SetWithTTL(service, user, password string, ttl time.Duration) error {
+	return f.error("set", f.set(context.Background(), service, user, []byte(password), nil, time.Now().Add(ttl)))
+}
+
+// SetItem stores the secret of item and its metadata in a sidecar file.
+func (f *fileProvider) SetItem(service, user string, item Item) error {
+	return f.error("set", f.set(context.Background(), service, user, item.Secret, &item, item.Expires))
+}
+
+// set writes data and updates the metadata, replacing label and attributes
+// by those of item if given.
+func (f *fileProvider) set(ctx context.Context, service, user string, data []byte, item *Item, expires time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock, err := f.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tokenPath, legacyPath, err := f.paths(service, user)
 	if err != nil {
 		return err
 	}
 
-	configDirPath := filepath.Dir(tokenPath)
-	if err := os.MkdirAll(configDirPath, 0700); err != nil {
-		return fmt.Errorf("failed to create config directory: %w", err)
+	serviceDir := filepath.Dir(tokenPath)
+	if err := mkdirAll(serviceDir, f.owner); err != nil {
+		return fmt.Errorf("failed to create service directory: %w", err)
+	}
+	if err := f.checkPerm(serviceDir); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err = f.seal(data, entryAD(service, user, false))
+	if err != nil {
+		return err
 	}
 
-	if err := os.WriteFile(tokenPath, []byte(password), 0600); err != nil {
+	if err := writeFile(tokenPath, data, f.owner); err != nil {
 		return fmt.Errorf("failed to write token file: %w", err)
 	}
 
+	metaPath := getMetaFilePath(tokenPath)
+	old, err := f.readMeta(metaPath, service, user)
+	if err != nil && legacyPath != "" {
+		// keep the metadata of a secret stored in the legacy layout
+		old, _ = f.readMeta(getMetaFilePath(legacyPath), service, user)
+	}
+
+	metaData, err := encodeMeta(updateMeta(old, item, time.Now(), expires))
+	if err != nil {
+		return err
+	}
+
+	metaData, err = f.seal(metaData, entryAD(service, user, true))
+	if err != nil {
+		return err
+	}
+
+	if err := mkdirAll(filepath.Dir(metaPath), f.owner); err != nil {
+		return fmt.Errorf("failed to create metadata directory: %w", err)
+	}
+	if err := f.checkPerm(filepath.Dir(metaPath)); err != nil {
+		return err
+	}
+
+	if err := writeFile(metaPath, metaData, f.owner); err != nil {
+		return fmt.Errorf("failed to write metadata file: %w", err)
+	}
+
+	// migrate a secret stored in the legacy layout
+	if legacyPath != "" {
+		if _, err := removeLegacyToken(legacyPath); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func (f *fileProvider) Get(service, user string) (string, error) {
-	tokenPath, err := getTokenFilePath(service, user)
+	return f.GetContext(context.Background(), service, user)
+}
+
+func (f *fileProvider) GetContext(ctx context.Context, service, user string) (string, error) {
+	item, err := f.get(ctx, service, user)
 	if err != nil {
-		return "", err
+		return "", f.error("get", err)
+	}
+
+	return string(item.Secret), nil
+}
+
+func (f *fileProvider) GetBytes(service, user string) ([]byte, error) {
+	item, err := f.get(context.Background(), service, user)
+	return item.Secret, f.error("get", err)
+}
+
+// get reads a secret and its metadata while holding the lock, see getItem.
+func (f *fileProvider) get(ctx context.Context, service, user string) (Item, error) {
+	if err := ctx.Err(); err != nil {
+		return Item{}, err
+	}
+
+	unlock, err := f.lock(ctx)
+	if err != nil {
+		return Item{}, err
+	}
+	defer unlock()
+
+	return f.getItem(ctx, service, user)
+}
+
+// GetItem gets a secret and its metadata. For secrets written before metadata
+// was stored, Modified is the modification time of the file.
+func (f *fileProvider) GetItem(service, user string) (Item, error) {
+	item, err := f.get(context.Background(), service, user)
+	return item, f.error("get", err)
+}
+
+// getItem reads a secret and its metadata, removing the secret if it has
+// expired. The caller holds the lock.
+func (f *fileProvider) getItem(ctx context.Context, service, user string) (Item, error) {
+	if err := ctx.Err(); err != nil {
+		return Item{}, err
+	}
+
+	tokenPath, legacyPath, err := f.paths(service, user)
+	if err != nil {
+		return Item{}, err
 	}
 
-	data, err := os.ReadFile(tokenPath)
+	data, err := f.readFile(tokenPath)
+	if os.IsNotExist(err) && legacyPath != "" {
+		tokenPath = legacyPath
+		data, err = f.readFile(tokenPath)
+		if errors.Is(err, syscall.EISDIR) {
+			// the directory of another service in the legacy layout
+			return Item{}, ErrNotFound
+		}
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
-			return "", ErrNotFound
+			return Item{}, ErrNotFound
+		}
+		return Item{}, fmt.Errorf("failed to read token file: %w", err)
+	}
+
+	data, err = f.open(data, entryAD(service, user, false))
+	if err != nil {
+		return Item{}, err
+	}
+
+	meta, err := f.readMeta(getMetaFilePath(tokenPath), service, user)
+	if err == nil {
+		if meta.expired(time.Now()) {
+			if err := f.removeFiles(service, user); err != nil && err != ErrNotFound {
+				return Item{}, err
+			}
+			return Item{}, ErrNotFound
 		}
-		return "", fmt.Errorf("failed to read token file: %w", err)
+		return meta.item(data), nil
+	}
+	if !os.IsNotExist(err) {
+		return Item{}, err
+	}
+
+	info, err := os.Stat(tokenPath)
+	if err != nil {
+		return Item{}, fmt.Errorf("failed to stat token file: %w", err)
 	}
 
-	return string(data), nil
+	return Item{Modified: info.ModTime(), Secret: data}, nil
+}
+
+// readMeta reads the metadata of the secret of service and user from
+// metaPath. A missing file is reported by an error satisfying os.IsNotExist.
+func (f *fileProvider) readMeta(metaPath, service, user string) (itemMeta, error) {
+	metaData, err := f.readFile(metaPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return itemMeta{}, err
+		}
+		return itemMeta{}, fmt.Errorf("failed to read metadata file: %w", err)
+	}
+
+	metaData, err = f.open(metaData, entryAD(service, user, true))
+	if err != nil {
+		return itemMeta{}, err
+	}
+
+	return decodeMeta(metaData), nil
+}
+
+// seal encrypts data if the files are encrypted and returns it as is
+// otherwise.
+func (f *fileProvider) seal(data, ad []byte) ([]byte, error) {
+	if f.crypter == nil {
+		return data, nil
+	}
+
+	root, err := f.root()
+	if err != nil {
+		return nil, err
+	}
+
+	return f.crypter.seal(root, data, ad)
+}
+
+// open decrypts data sealed by seal.
+func (f *fileProvider) open(data, ad []byte) ([]byte, error) {
+	if f.crypter == nil {
+		return data, nil
+	}
+
+	root, err := f.root()
+	if err != nil {
+		return nil, err
+	}
+
+	return f.crypter.open(root, data, ad)
 }
 
 func (f *fileProvider) Delete(service, user string) error {
-	tokenPath, err := getTokenFilePath(service, user)
+	return f.DeleteContext(context.Background(), service, user)
+}
+
+func (f *fileProvider) DeleteContext(ctx context.Context, service, user string) error {
+	return f.error("delete", f.remove(ctx, service, user))
+}
+
+func (f *fileProvider) remove(ctx context.Context, service, user string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock, err := f.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return f.removeFiles(service, user)
+}
+
+// removeFiles removes the secret of service and user and its metadata. The
+// caller holds the lock.
+func (f *fileProvider) removeFiles(service, user string) error {
+	tokenPath, legacyPath, err := f.paths(service, user)
+	if err != nil {
+		return err
+	}
+
+	found, err := removeToken(tokenPath)
 	if err != nil {
 		return err
 	}
 
+	// remove the secret in the legacy layout too, in case it wasn't migrated
+	// yet
+	if legacyPath != "" {
+		foundLegacy, err := removeLegacyToken(legacyPath)
+		if err != nil {
+			return err
+		}
+		found = found || foundLegacy
+	}
+
+	if !found {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// removeToken removes the secret stored at tokenPath and its metadata.
+// found is false if there was no secret.
+func removeToken(tokenPath string) (found bool, err error) {
 	if err := os.Remove(tokenPath); err != nil {
 		if os.IsNotExist(err) {
-			return nil
+			return false, nil
 		}
-		return fmt.Errorf("failed to remove token file: %w", err)
+		return false, fmt.Errorf("failed to remove token file: %w", err)
 	}
 
-	return nil
+	if err := os.Remove(getMetaFilePath(tokenPath)); err != nil && !os.IsNotExist(err) {
+		return true, fmt.Errorf("failed to remove metadata file: %w", err)
+	}
+
+	return true, nil
+}
+
+// removeLegacyToken removes the secret stored at legacyPath, like
+// removeToken, along with the directories it leaves empty.
+func removeLegacyToken(legacyPath string) (found bool, err error) {
+	info, err := os.Lstat(legacyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat token file: %w", err)
+	}
+	if info.IsDir() {
+		// the directory of another service in the legacy layout
+		return false, nil
+	}
+
+	if _, err := removeToken(legacyPath); err != nil {
+		return true, err
+	}
+
+	// fails if other secrets are left, which is fine
+	_ = os.Remove(filepath.Dir(getMetaFilePath(legacyPath)))
+	_ = os.Remove(filepath.Dir(legacyPath))
+
+	return true, nil
 }
 
 func (f *fileProvider) DeleteAll(service string) error {
+	return f.DeleteAllContext(context.Background(), service)
+}
+
+func (f *fileProvider) DeleteAllContext(ctx context.Context, service string) error {
+	return f.error("delete all", f.removeAll(ctx, service))
+}
+
+func (f *fileProvider) removeAll(ctx context.Context, service string) error {
 	if service == "" {
 		return ErrNotFound
 	}
 
-	configDirPath, err := os.UserConfigDir()
+	serviceDir, legacyDir, err := f.serviceDirs(service)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := f.lock(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get config directory: %w", err)
+		return err
 	}
+	defer unlock()
 
-	serviceDir := filepath.Join(configDirPath, "go-keyring", service)
+	if err := os.RemoveAll(serviceDir); err != nil {
+		return fmt.Errorf("failed to remove service directory: %w", err)
+	}
 
+	if legacyDir == "" {
+		return nil
+	}
+
+	return removeLegacyService(ctx, legacyDir)
+}
+
+// removeLegacyService removes the secrets stored in serviceDir in the legacy
+// layout. As it may hold the directories of other services, e.g. for a
+// service "a" and another one "a/b", only files are removed.
+func removeLegacyService(ctx context.Context, serviceDir string) error {
 	entries, err := os.ReadDir(serviceDir)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -93,6 +572,10 @@ func (f *fileProvider) DeleteAll(service string) error {
 	}
 
 	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		if !entry.IsDir() {
 			filePath := filepath.Join(serviceDir, entry.Name())
 			if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
@@ -101,6 +584,10 @@ func (f *fileProvider) DeleteAll(service string) error {
 		}
 	}
 
+	if err := os.RemoveAll(filepath.Join(serviceDir, metaDirName)); err != nil {
+		return fmt.Errorf("failed to remove metadata directory: %w", err)
+	}
+
 	if err := os.Remove(serviceDir); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to remove service directory: %w", err)
 	}
@@ -108,11 +595,561 @@ func (f *fileProvider) DeleteAll(service string) error {
 	return nil
 }
 
-func getTokenFilePath(service, user string) (string, error) {
-	configDirPath, err := os.UserConfigDir()
+func (f *fileProvider) List(service string) ([]string, error) {
+	return f.ListContext(context.Background(), service)
+}
+
+func (f *fileProvider) ListContext(ctx context.Context, service string) ([]string, error) {
+	users, err := f.list(ctx, service)
+	return users, f.error("list", err)
+}
+
+func (f *fileProvider) list(ctx context.Context, service string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	serviceDir, legacyDir, err := f.serviceDirs(service)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := f.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	candidates, err := f.readUsers(serviceDir, decodeFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	if legacyDir != "" {
+		legacyUsers, err := f.readUsers(legacyDir, func(name string) (string, bool) {
+			return name, true
+		})
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, legacyUsers...)
+	}
+
+	// a secret might be stored in both layouts until it's migrated
+	seen := make(map[string]bool)
+	users := []string{}
+
+	for _, user := range candidates {
+		if seen[user] {
+			continue
+		}
+		seen[user] = true
+
+		// skip, and thereby remove, expired secrets
+		if _, err := f.getItem(ctx, service, user); err != nil {
+			if err == ErrNotFound {
+				continue
+			}
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	return users, nil
+}
+
+// services returns the services which have a directory below the root, in
+// the current or the legacy layout.
+func (f *fileProvider) services() ([]string, error) {
+	root, err := f.root()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read keyring directory: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	services := []string{}
+
+	for _, entry := range entries {
+		// hidden files of the backend and legacy secrets of an empty service
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		service, ok := decodeFileName(entry.Name())
+		if !ok {
+			service = entry.Name()
+		}
+		if !seen[service] {
+			seen[service] = true
+			services = append(services, service)
+		}
+	}
+
+	return services, nil
+}
+
+// readUsers returns the users of the files in serviceDir, using decode to
+// map file names to users.
+func (f *fileProvider) readUsers(serviceDir string, decode func(string) (string, bool)) ([]string, error) {
+	if err := f.checkPerm(serviceDir); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(serviceDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read service directory: %w", err)
+	}
+
+	users := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if user, ok := decode(entry.Name()); ok {
+			users = append(users, user)
+		}
+	}
+
+	return users, nil
+}
+
+// error maps err returned by a file operation during op onto the errors of
+// this package.
+func (f *fileProvider) error(op string, err error) error {
+	return fileError(f.name(), op, err)
+}
+
+// fileError maps err returned by a file operation of backend during op onto
+// the errors of this package.
+func fileError(backend, op string, err error) error {
+	if errors.Is(err, fs.ErrPermission) {
+		err = kindError{ErrAccessDenied, err}
+	}
+	return newBackendError(backend, op, err)
+}
+
+// name returns the registered name of the backend.
+func (f *fileProvider) name() string {
+	switch {
+	case f.vault && f.crypter != nil:
+		return "encrypted-vault"
+	case f.vault:
+		return "vault"
+	case f.crypter != nil && f.crypter.cache != nil:
+		return "hybrid-file"
+	case f.crypter != nil:
+		return "encrypted-file"
+	}
+	return "file"
+}
+
+// root returns the directory holding the secrets, see WithFileDir. Encrypted
+// secrets are kept apart from plaintext ones.
+func (f *fileProvider) root() (string, error) {
+	name := "go-keyring"
+	switch {
+	case f.vault:
+		name = "go-keyring-vault"
+	case f.crypter != nil:
+		name = "go-keyring-encrypted"
+	}
+
+	if f.dir != "" {
+		return filepath.Join(f.dir, name), nil
+	}
+	if dir := os.Getenv(FileDirEnv); dir != "" {
+		return filepath.Join(dir, name), nil
+	}
+
+	// the caller's XDG directories don't apply to another user
+	if f.owner != nil {
+		if root := filepath.Join(f.owner.home, ".config", name); !f.vault && hasSecrets(root) {
+			return root, nil
+		}
+		return filepath.Join(f.owner.home, ".local", "state", name), nil
+	}
+
+	// keep using the config directory if secrets were stored there before
+	if configDir, err := os.UserConfigDir(); err == nil && !f.vault {
+		if root := filepath.Join(configDir, name); hasSecrets(root) {
+			return root, nil
+		}
+	}
+
+	stateDir, err := userStateDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get state directory: %w", err)
+	}
+	return filepath.Join(stateDir, name), nil
+}
+
+// userStateDir returns $XDG_STATE_HOME, defaulting to ~/.local/state.
+func userStateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return dir, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state"), nil
+}
+
+// hasSecrets reports whether the directory root holds anything but the
+// config file, which shares the directory go-keyring below the config
+// directory, and the lock file. A directory at the path of the config file
+// holds the secrets of a service named like it in the legacy layout.
+func hasSecrets(root string) bool {
+	dir, err := os.Open(root)
+	if err != nil {
+		return false
+	}
+	defer dir.Close()
+
+	for {
+		names, err := dir.Readdirnames(16)
+		for _, name := range names {
+			if name == lockFileName {
+				continue
+			}
+			if name == filepath.Base(configFile) && !isDir(filepath.Join(root, name)) {
+				continue
+			}
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// isDir reports whether path is a directory, not following symlinks.
+func isDir(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.IsDir()
+}
+
+// readFile reads the file at path after checking its permissions and those
+// of its directory, see checkPerm.
+func (f *fileProvider) readFile(path string) ([]byte, error) {
+	if err := f.checkPerm(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if err := f.checkPerm(path); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// checkPerm returns ErrInsecurePermissions if the directory or file at path
+// isn't owned by the current user, or the owner set by WithUID, or is
+// accessible by others, i.e. its mode
+// isn't within 0700 or 0600 respectively. If repair is set, the permissions
+// of the user's own directories and files are tightened instead. A missing
+// file is reported by an error satisfying os.IsNotExist.
+func (f *fileProvider) checkPerm(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return err
+		}
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	uid := os.Getuid()
+	if f.owner != nil {
+		uid = f.owner.uid
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != uid {
+		return kindError{ErrInsecurePermissions, fmt.Errorf("%s is owned by uid %d instead of %d", path, st.Uid, uid)}
+	}
+
+	perm := os.FileMode(0600)
+	if info.IsDir() {
+		perm = 0700
+	}
+	if info.Mode().Perm()&^perm == 0 {
+		return nil
+	}
+
+	if !f.repair {
+		return kindError{ErrInsecurePermissions, fmt.Errorf("%s has mode %04o, expected %04o", path, info.Mode().Perm(), perm)}
+	}
+	if err := os.Chmod(path, info.Mode().Perm()&perm); err != nil {
+		return fmt.Errorf("failed to repair permissions: %w", err)
+	}
+	return nil
+}
+
+// metaDirName is the directory below a service's directory holding the
+// metadata of its secrets, one file per user.
+const metaDirName = ".meta"
+
+// getMetaFilePath returns the path of the metadata file of the secret stored
+// at tokenPath.
+func getMetaFilePath(tokenPath string) string {
+	return filepath.Join(filepath.Dir(tokenPath), metaDirName, filepath.Base(tokenPath))
+}
+
+// checkName returns ErrInvalidName if name can't be stored, see
+// checkFileName, and for the encrypted backends checkEntryName.
+func (f *fileProvider) checkName(name string) error {
+	if err := checkFileName(name); err != nil {
+		return err
+	}
+	if f.crypter != nil {
+		return checkEntryName(name)
+	}
+	return nil
+}
+
+// paths returns the path of the file holding the secret of service and user,
+// see encodeFileName, and its path in the legacy layout, which joined service
+// and user as they are. legacyPath is empty if there's no such path.
+func (f *fileProvider) paths(service, user string) (tokenPath, legacyPath string, err error) {
+	if err := f.checkName(user); err != nil {
+		return "", "", err
+	}
+
+	serviceDir, legacyDir, err := f.serviceDirs(service)
+	if err != nil {
+		return "", "", err
+	}
+
+	tokenPath = filepath.Join(serviceDir, encodeFileName(user))
+	if legacyDir != "" {
+		legacyPath = legacyFilePath(legacyDir, user)
+	}
+
+	return tokenPath, legacyPath, nil
+}
+
+// serviceDirs returns the directory holding the secrets of service and its
+// directory in the legacy layout, which is empty if there's no such
+// directory.
+func (f *fileProvider) serviceDirs(service string) (serviceDir, legacyDir string, err error) {
+	if err := f.checkName(service); err != nil {
+		return "", "", err
+	}
+
+	root, err := f.root()
+	if err != nil {
+		return "", "", err
+	}
+
+	serviceDir = filepath.Join(root, encodeFileName(service))
+	// the root holds the hidden files of the backend, e.g. lockFileName, and
+	// below the config directory the config file, which isn't the directory
+	// of a service named like it
+	if !strings.HasPrefix(service, ".") {
+		legacyDir = legacyFilePath(root, service)
+		if legacyDir != "" && service == filepath.Base(configFile) && !isDir(legacyDir) {
+			legacyDir = ""
+		}
+	}
+
+	return serviceDir, legacyDir, nil
+}
+
+// legacyFilePath joins dir and name, a service or user, as the legacy layout
+// did. It returns an empty string if the result isn't below dir, e.g. for an
+// empty name.
+func legacyFilePath(dir, name string) string {
+	if strings.ContainsRune(name, 0) {
+		return ""
+	}
+	path := filepath.Join(dir, name)
+	if !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+		return ""
+	}
+	return path
+}
+
+// lockFileName is the file below the root of a file backend which is locked
+// while secrets are read or written.
+const lockFileName = ".lock"
+
+// lock takes an exclusive advisory lock on the keyring, serializing the
+// operations of all processes and goroutines. It waits until the lock is
+// free or ctx is done. Operations holding the lock must not take it again.
+func (f *fileProvider) lock(ctx context.Context) (unlock func(), err error) {
+	root, err := f.root()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mkdirAll(root, f.owner); err != nil {
+		return nil, fmt.Errorf("failed to create keyring directory: %w", err)
+	}
+	if err := f.checkPerm(root); err != nil {
+		return nil, err
+	}
+	if f.crypter != nil {
+		if err := f.checkPerm(filepath.Join(root, keyFileName)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(filepath.Join(root, lockFileName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := f.owner.chown(file.Name()); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if err := flock(ctx, file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// closing the file releases the lock
+	return func() { file.Close() }, nil
+}
+
+// lockRetryInterval is how often flock retries to take a lock held by
+// another process while waiting for a context.
+const lockRetryInterval = 5 * time.Millisecond
+
+// flock takes an exclusive lock on file. As flock(2) can't be interrupted,
+// the lock is polled for if ctx can be canceled.
+func flock(ctx context.Context, file *os.File) error {
+	fd := int(file.Fd())
+	if ctx.Done() == nil {
+		for {
+			err := unix.Flock(fd, unix.LOCK_EX)
+			if err == unix.EINTR {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to lock keyring: %w", err)
+			}
+			return nil
+		}
+	}
+
+	for {
+		err := unix.Flock(fd, unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			return nil
+		}
+		if err != unix.EWOULDBLOCK && err != unix.EINTR {
+			return fmt.Errorf("failed to lock keyring: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// writeFile replaces the file at path with data atomically: data is written
+// to a temporary file, which is synced and renamed to path, and the rename is
+// made durable by syncing the directory. After a crash, path holds either the
+// old or the new data, never a truncated file. The file is given to owner if
+// it isn't nil.
+func writeFile(path string, data []byte, owner *fileOwner) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := writeTempFile(dir, data, owner)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// writeTempFile writes data to a new temporary file in dir and returns its
+// path. The file is synced and only readable by the user, or by owner if it
+// isn't nil.
+func writeTempFile(dir string, data []byte, owner *fileOwner) (string, error) {
+	f, err := os.CreateTemp(dir, ".tmp-*")
 	if err != nil {
-		return "", fmt.Errorf("failed to get config directory: %w", err)
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
 	}
 
-	return filepath.Join(configDirPath, "go-keyring", service, user), nil
+	err = owner.chown(f.Name())
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	return f.Name(), nil
+}
+
+// mkdirAll creates the directory path and any missing parents with mode 0700.
+// The directories it creates are given to owner if it isn't nil.
+func mkdirAll(path string, owner *fileOwner) error {
+	if owner == nil {
+		return os.MkdirAll(path, 0700)
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("%s isn't a directory", path)
+		}
+		return nil
+	}
+
+	if parent := filepath.Dir(path); parent != path {
+		if err := mkdirAll(parent, owner); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Mkdir(path, 0700); err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	return owner.chown(path)
+}
+
+// syncDir syncs the directory dir, making renames and links in it durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %w", err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %w", err)
+	}
+	return nil
 }
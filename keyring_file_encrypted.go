@@ -0,0 +1,430 @@
+//go:build linux
+
+package keyring
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// keyFileName is the file below the root of the encrypted file backend
+	// holding the data encryption key, sealed with the passphrase.
+	keyFileName = ".key"
+
+	// entryVersion is the first byte of every sealed file.
+	entryVersion = 1
+
+	// Argon2id parameters for new key files, following the second
+	// recommendation of RFC 9106. The parameters used are stored in the key
+	// file, so they can be raised without breaking existing keyrings.
+	kdfTime    = 3
+	kdfMemory  = 64 * 1024
+	kdfThreads = 4
+
+	// Upper bounds of the Argon2id parameters read from key files, so that a
+	// corrupted one can't make unlocking exhaust the memory or hang.
+	kdfMaxTime   = 64
+	kdfMaxMemory = 1024 * 1024
+
+	// kdfSaltSize is the size of the Argon2id salt, sealedKeySize the one of
+	// the nonce followed by the sealed 32 byte data encryption key.
+	kdfSaltSize   = 16
+	sealedKeySize = 12 + 32 + 16
+)
+
+// keyFile is the encoding of keyFileName. The data encryption key is random
+// and sealed with a key derived from the passphrase, so changing the
+// passphrase doesn't require re-encrypting the secrets.
+type keyFile struct {
+	Version int `json:"version"`
+	// ID identifies the data encryption key. It stays the same on rekeying.
+	ID      string `json:"id"`
+	KDF     string `json:"kdf"`
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+	// Key is the nonce followed by the sealed data encryption key.
+	Key []byte `json:"key"`
+}
+
+// keyFileAD returns the additional data authenticated with the sealed data
+// encryption key with the given ID.
+func keyFileAD(id string) []byte {
+	return []byte("go-keyring key v1\x00" + id)
+}
+
+type encryptedFileProvider struct {
+	*fileProvider
+}
+
+func init() {
+	Register("encrypted-file", func(cfg Config) (Keyring, error) {
+		env := cfg.Options["passphrase-env"]
+		if env == "" {
+			env = PassphraseEnv
+		}
+		e := newEncryptedFileProvider(defaultPassphrase(env))
+		if err := e.configure(cfg); err != nil {
+			return nil, e.error("open", err)
+		}
+		return e, nil
+	})
+}
+
+// WithEncryptedFile selects the backend storing secrets as files encrypted
+// with AES-256-GCM, see WithFileDir for their location. The key is protected by
+// a passphrase returned by passphrase, which is stretched with Argon2id. If
+// passphrase is nil, it's read from the environment variable PassphraseEnv or
+// prompted for on the terminal.
+func WithEncryptedFile(passphrase PassphraseFunc) Option {
+	if passphrase == nil {
+		passphrase = defaultPassphrase(PassphraseEnv)
+	}
+	return func(o *options) {
+		o.open = func() (Keyring, error) {
+			f, err := o.newFileProvider(&fileCrypter{passphrase: passphrase})
+			if err != nil {
+				return nil, err
+			}
+			return encryptedFileProvider{f}, nil
+		}
+	}
+}
+
+func newEncryptedFileProvider(passphrase PassphraseFunc) encryptedFileProvider {
+	return encryptedFileProvider{&fileProvider{crypter: &fileCrypter{passphrase: passphrase}}}
+}
+
+// Rekey protects the keyring with a new passphrase.
+func (e encryptedFileProvider) Rekey(passphrase []byte) error {
+	root, err := e.root()
+	if err != nil {
+		return e.error("rekey", err)
+	}
+
+	unlock, err := e.lock(context.Background())
+	if err != nil {
+		return e.error("rekey", err)
+	}
+	defer unlock()
+
+	return e.error("rekey", e.crypter.rekey(root, passphrase))
+}
+
+// fileCrypter seals and opens the files of the encrypted file backend.
+type fileCrypter struct {
+	passphrase PassphraseFunc
+	// cache holds the unsealed key between processes if set, see
+	// WithHybridFile.
+	cache keyCache
+	// owner is given the key file if set, see WithUID.
+	owner *fileOwner
+
+	// mu guards id, key and aead, which are set once unlocked.
+	mu   sync.Mutex
+	id   string
+	key  []byte
+	aead cipher.AEAD
+}
+
+// entryAD returns the additional data authenticated with the secret, or its
+// metadata, of service and user. This binds the sealed data to its location.
+// The fields are separated by NUL bytes, which checkEntryName keeps out of
+// service and user, so that different locations never share additional data.
+func entryAD(service, user string, meta bool) []byte {
+	ad := "go-keyring\x00" + service + "\x00" + user
+	if meta {
+		ad += "\x00meta"
+	}
+	return []byte(ad)
+}
+
+// checkEntryName returns ErrInvalidName if name contains a NUL byte, see
+// entryAD.
+func checkEntryName(name string) error {
+	if strings.ContainsRune(name, 0) {
+		return kindError{ErrInvalidName, fmt.Errorf("%q contains a NUL byte", name)}
+	}
+	return nil
+}
+
+// seal encrypts data with the key of the keyring at root.
+func (c *fileCrypter) seal(root string, data, ad []byte) ([]byte, error) {
+	aead, err := c.unlock(root)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(data)+aead.Overhead())
+	sealed[0] = entryVersion
+	if _, err := rand.Read(sealed[1:]); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(sealed, sealed[1:], data, append(sealed[:1:1], ad...)), nil
+}
+
+// open decrypts data sealed by seal, returning ErrTampered if it fails
+// authentication.
+func (c *fileCrypter) open(root string, data, ad []byte) ([]byte, error) {
+	aead, err := c.unlock(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 1+aead.NonceSize() || data[0] != entryVersion {
+		return nil, ErrTampered
+	}
+
+	nonce, ciphertext := data[1:1+aead.NonceSize()], data[1+aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, append(data[:1:1], ad...))
+	if err != nil {
+		return nil, ErrTampered
+	}
+
+	return plain, nil
+}
+
+// unlock returns the cipher of the keyring at root, reading the key file with
+// the passphrase on first use. If there is no key file yet, a new key is
+// created.
+func (c *fileCrypter) unlock(root string) (cipher.AEAD, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.aead != nil {
+		return c.aead, nil
+	}
+
+	kf, err := readKeyFile(root)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if exists && c.cache != nil {
+		key, err := c.cache.load(kf.ID)
+		if err != nil {
+			return nil, err
+		}
+		if key != nil {
+			return c.setKey(kf.ID, key)
+		}
+	}
+
+	passphrase, err := c.passphrase()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for i := range passphrase {
+			passphrase[i] = 0
+		}
+	}()
+
+	var key []byte
+	if exists {
+		key, err = kf.open(passphrase)
+	} else {
+		kf, key, err = createKeyFile(root, passphrase, c.owner)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.cache != nil {
+		if err := c.cache.store(kf.ID, key); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.setKey(kf.ID, key)
+}
+
+// setKey makes key the key of the unlocked keyring.
+func (c *fileCrypter) setKey(id string, key []byte) (cipher.AEAD, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.id, c.key, c.aead = id, key, aead
+	return aead, nil
+}
+
+// rekey seals the key of the keyring at root with a new passphrase.
+func (c *fileCrypter) rekey(root string, passphrase []byte) error {
+	if _, err := c.unlock(root); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := sealKey(c.id, c.key, passphrase)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFile(filepath.Join(root, keyFileName), data, c.owner); err != nil {
+		return fmt.Errorf("failed to replace key file: %w", err)
+	}
+
+	return nil
+}
+
+// readKeyFile reads the key file of the keyring at root. A missing key file
+// is reported by an error satisfying os.IsNotExist.
+func readKeyFile(root string) (keyFile, error) {
+	data, err := os.ReadFile(filepath.Join(root, keyFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return keyFile{}, err
+		}
+		return keyFile{}, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	var kf keyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return keyFile{}, fmt.Errorf("failed to decode key file: %w", err)
+	}
+	if kf.Version != 1 || kf.KDF != "argon2id" {
+		return keyFile{}, fmt.Errorf("unsupported key file version %d with kdf %q", kf.Version, kf.KDF)
+	}
+	if err := kf.check(); err != nil {
+		return keyFile{}, kindError{ErrTampered, fmt.Errorf("invalid key file: %w", err)}
+	}
+
+	return kf, nil
+}
+
+// check validates the parameters of the key file before they are passed to
+// Argon2id, which panics on some of them.
+func (kf keyFile) check() error {
+	switch {
+	case kf.Time < 1 || kf.Time > kdfMaxTime:
+		return fmt.Errorf("time %d outside of [1, %d]", kf.Time, kdfMaxTime)
+	case kf.Threads < 1:
+		return errors.New("threads 0")
+	case kf.Memory < 8*uint32(kf.Threads) || kf.Memory > kdfMaxMemory:
+		return fmt.Errorf("memory %d outside of [%d, %d]", kf.Memory, 8*uint32(kf.Threads), kdfMaxMemory)
+	case len(kf.Salt) != kdfSaltSize:
+		return fmt.Errorf("salt of %d bytes, expected %d", len(kf.Salt), kdfSaltSize)
+	case len(kf.Key) != sealedKeySize:
+		return fmt.Errorf("sealed key of %d bytes, expected %d", len(kf.Key), sealedKeySize)
+	}
+	return nil
+}
+
+// open unseals the key with passphrase.
+func (kf keyFile) open(passphrase []byte) ([]byte, error) {
+	aead, err := newAEAD(argon2.IDKey(passphrase, kf.Salt, kf.Time, kf.Memory, kf.Threads, 32))
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := aead.Open(nil, kf.Key[:aead.NonceSize()], kf.Key[aead.NonceSize():], keyFileAD(kf.ID))
+	if err != nil {
+		// a modified key file can't be told apart from a wrong passphrase
+		return nil, ErrBadPassphrase
+	}
+
+	return key, nil
+}
+
+// createKeyFile creates the key file of the keyring at root with a new random
+// key, given to owner if it isn't nil. If another process created it in the
+// meantime, its key is used.
+func createKeyFile(root string, passphrase []byte, owner *fileOwner) (keyFile, []byte, error) {
+	random := make([]byte, 32+16)
+	if _, err := rand.Read(random); err != nil {
+		return keyFile{}, nil, err
+	}
+	key, id := random[:32], hex.EncodeToString(random[32:])
+
+	data, err := sealKey(id, key, passphrase)
+	if err != nil {
+		return keyFile{}, nil, err
+	}
+
+	if err := mkdirAll(root, owner); err != nil {
+		return keyFile{}, nil, fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	tmp, err := writeTempFile(root, data, owner)
+	if err != nil {
+		return keyFile{}, nil, err
+	}
+	defer os.Remove(tmp)
+
+	// unlike rename, link doesn't replace a key file created concurrently
+	if err := os.Link(tmp, filepath.Join(root, keyFileName)); err != nil {
+		if !os.IsExist(err) {
+			return keyFile{}, nil, fmt.Errorf("failed to create key file: %w", err)
+		}
+		kf, err := readKeyFile(root)
+		if err != nil {
+			return keyFile{}, nil, err
+		}
+		key, err := kf.open(passphrase)
+		return kf, key, err
+	}
+
+	if err := syncDir(root); err != nil {
+		return keyFile{}, nil, err
+	}
+
+	return keyFile{ID: id}, key, nil
+}
+
+// sealKey encodes key with the given ID as key file protected by
+// passphrase.
+func sealKey(id string, key, passphrase []byte) ([]byte, error) {
+	kf := keyFile{
+		Version: 1,
+		ID:      id,
+		KDF:     "argon2id",
+		Salt:    make([]byte, kdfSaltSize),
+		Time:    kdfTime,
+		Memory:  kdfMemory,
+		Threads: kdfThreads,
+	}
+	if _, err := rand.Read(kf.Salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(argon2.IDKey(passphrase, kf.Salt, kf.Time, kf.Memory, kf.Threads, 32))
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	kf.Key = aead.Seal(nonce, nonce, key, keyFileAD(kf.ID))
+
+	return json.Marshal(kf)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.New("invalid key length")
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,214 @@
+//go:build linux
+
+package keyring
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func staticPassphrase(passphrase string) PassphraseFunc {
+	return func() ([]byte, error) {
+		return []byte(passphrase), nil
+	}
+}
+
+func TestEncryptedFileProvider(t *testing.T) {
+	setTempFileDirs(t)
+	provider := newEncryptedFileProvider(staticPassphrase("correct horse"))
+
+	if err := provider.Set(service, user, password); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	pw, err := provider.Get(service, user)
+	if err != nil {
+		t.Fatalf("Failed to get password: %v", err)
+	}
+	if pw != password {
+		t.Errorf("Expected password %s, got %s", password, pw)
+	}
+
+	tokenPath, _, err := provider.paths(service, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(tokenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(password)) {
+		t.Errorf("Expected token file to be encrypted")
+	}
+
+	// a fresh instance unlocks the existing key
+	pw, err = newEncryptedFileProvider(staticPassphrase("correct horse")).Get(service, user)
+	if err != nil || pw != password {
+		t.Errorf("Expected password %s, got %q and %v", password, pw, err)
+	}
+
+	_, err = newEncryptedFileProvider(staticPassphrase("wrong")).Get(service, user)
+	if !errors.Is(err, ErrBadPassphrase) {
+		t.Errorf("Expected ErrBadPassphrase, got %v", err)
+	}
+}
+
+func TestEncryptedFileProviderTampered(t *testing.T) {
+	setTempFileDirs(t)
+	provider := newEncryptedFileProvider(staticPassphrase("correct horse"))
+
+	if err := provider.Set(service, user, password); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if err := provider.Set(service, user+"2", password+"2"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	tokenPath, _, err := provider.paths(service, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPath, _, err := provider.paths(service, user+"2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a file moved to another user doesn't authenticate
+	data, err := os.ReadFile(otherPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tokenPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = provider.Get(service, user)
+	if !errors.Is(err, ErrTampered) {
+		t.Errorf("Expected ErrTampered, got %v", err)
+	}
+
+	// neither does a modified one
+	data[len(data)-1] ^= 1
+	if err := os.WriteFile(otherPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = provider.Get(service, user+"2")
+	if !errors.Is(err, ErrTampered) {
+		t.Errorf("Expected ErrTampered, got %v", err)
+	}
+}
+
+func TestEncryptedFileProviderRekey(t *testing.T) {
+	setTempFileDirs(t)
+	provider := newEncryptedFileProvider(staticPassphrase("old"))
+
+	if err := provider.Set(service, user, password); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	var rekeyer Rekeyer = provider
+	if err := rekeyer.Rekey([]byte("new")); err != nil {
+		t.Fatalf("Failed to rekey: %v", err)
+	}
+
+	pw, err := newEncryptedFileProvider(staticPassphrase("new")).Get(service, user)
+	if err != nil || pw != password {
+		t.Errorf("Expected password %s, got %q and %v", password, pw, err)
+	}
+
+	_, err = newEncryptedFileProvider(staticPassphrase("old")).Get(service, user)
+	if !errors.Is(err, ErrBadPassphrase) {
+		t.Errorf("Expected ErrBadPassphrase, got %v", err)
+	}
+}
+
+func TestEncryptedFileProviderInvalidKeyFile(t *testing.T) {
+	setTempFileDirs(t)
+	provider := newEncryptedFileProvider(staticPassphrase("correct horse"))
+
+	if err := provider.Set(service, user, password); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	root, err := provider.root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(root, keyFileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var valid keyFile
+	if err := json.Unmarshal(data, &valid); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, modify := range map[string]func(kf *keyFile){
+		"time 0":          func(kf *keyFile) { kf.Time = 0 },
+		"time too high":   func(kf *keyFile) { kf.Time = kdfMaxTime + 1 },
+		"threads 0":       func(kf *keyFile) { kf.Threads = 0 },
+		"memory too low":  func(kf *keyFile) { kf.Memory = 8*uint32(kf.Threads) - 1 },
+		"memory too high": func(kf *keyFile) { kf.Memory = 1 << 31 },
+		"short salt":      func(kf *keyFile) { kf.Salt = kf.Salt[:8] },
+		"short key":       func(kf *keyFile) { kf.Key = kf.Key[:12] },
+		"long key":        func(kf *keyFile) { kf.Key = append(kf.Key, 0) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			kf := valid
+			kf.Salt = append([]byte(nil), valid.Salt...)
+			kf.Key = append([]byte(nil), valid.Key...)
+			modify(&kf)
+			data, err := json.Marshal(kf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = newEncryptedFileProvider(staticPassphrase("correct horse")).Get(service, user)
+			if !errors.Is(err, ErrTampered) {
+				t.Errorf("Expected ErrTampered, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEncryptedFileProviderNUL(t *testing.T) {
+	setTempFileDirs(t)
+	provider := newEncryptedFileProvider(staticPassphrase("correct horse"))
+
+	err := provider.Set("a\x00b", "c", password)
+	if !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Expected ErrInvalidName, got %v", err)
+	}
+
+	// a secret of ("a\x00b", "c") moved to ("a", "b\x00c") isn't accepted,
+	// though the fields would be joined the same way
+	sealed, err := provider.seal([]byte(password), entryAD("a\x00b", "c", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := provider.root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	swapped := filepath.Join(root, encodeFileName("a"), encodeFileName("b\x00c"))
+	if err := os.MkdirAll(filepath.Dir(swapped), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(swapped, sealed, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pw, err := provider.Get("a", "b\x00c")
+	if !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Expected ErrInvalidName, got %q and %v", pw, err)
+	}
+}
@@ -0,0 +1,122 @@
+//go:build linux
+
+package keyring
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// keyCache keeps the unsealed key of an encrypted file keyring, so that the
+// passphrase isn't needed on every start.
+type keyCache interface {
+	// load returns the key with the given ID, or nil if it isn't cached.
+	load(id string) ([]byte, error)
+	// store caches key with the given ID.
+	store(id string, key []byte) error
+}
+
+// keyctlKeyCache caches keys in a kernel keyring, by default the persistent
+// one, where they survive logout but not a reboot.
+type keyctlKeyCache struct {
+	// keyctl selects the kernel keyring, see WithKeyctlKeyring and WithUID.
+	keyctl keyctlProvider
+}
+
+func init() {
+	Register("hybrid-file", func(cfg Config) (Keyring, error) {
+		keyctl, err := newKeyctlProvider(KeyctlKeyring(cfg.Options["keyring"]), cfg.Options["keyring-name"], 0, nil)
+		if err != nil {
+			return nil, keyctlError("open", err)
+		}
+		if _, err := keyctl.getKeyring(); err != nil {
+			return nil, keyctlError("open", err)
+		}
+		env := cfg.Options["passphrase-env"]
+		if env == "" {
+			env = PassphraseEnv
+		}
+		h := newHybridFileProvider(defaultPassphrase(env), keyctl)
+		if err := h.configure(cfg); err != nil {
+			return nil, h.error("open", err)
+		}
+		return h, nil
+	})
+}
+
+// WithHybridFile selects the backend storing secrets in the same encrypted
+// files as WithEncryptedFile, but keeping the key in the persistent kernel
+// keyring. The recovery passphrase returned by recovery is only needed when
+// the key isn't in the kernel keyring, i.e. on first use and after a reboot,
+// and the key is put back into the kernel keyring afterwards. If recovery is
+// nil, it's read from the environment variable PassphraseEnv or prompted for
+// on the terminal. WithKeyctlKeyring selects another kernel keyring for the
+// key.
+func WithHybridFile(recovery PassphraseFunc) Option {
+	if recovery == nil {
+		recovery = defaultPassphrase(PassphraseEnv)
+	}
+	return func(o *options) {
+		o.open = func() (Keyring, error) {
+			keyctl, err := newKeyctlProvider(KeyctlKeyring(o.keyctlKeyring), o.keyctlKeyringName, 0, nil)
+			if err != nil {
+				return nil, keyctlError("open", err)
+			}
+			f, err := o.newFileProvider(&fileCrypter{passphrase: recovery, cache: keyctlKeyCache{keyctl}})
+			if err != nil {
+				return nil, err
+			}
+			return encryptedFileProvider{f}, nil
+		}
+	}
+}
+
+// newHybridFileProvider returns a hybrid file backend caching the key in the
+// keyring of keyctl.
+func newHybridFileProvider(recovery PassphraseFunc, keyctl keyctlProvider) encryptedFileProvider {
+	return encryptedFileProvider{&fileProvider{crypter: &fileCrypter{passphrase: recovery, cache: keyctlKeyCache{keyctl}}}}
+}
+
+// description returns the description of the kernel key caching the key with
+// the given ID.
+func (keyctlKeyCache) description(id string) string {
+	return "go-keyring:file-key:" + id
+}
+
+func (c keyctlKeyCache) load(id string) ([]byte, error) {
+	k := c.keyctl
+	keyringID, err := k.getKeyring()
+	if err != nil {
+		return nil, keyctlError("get", err)
+	}
+
+	keyID, err := unix.KeyctlSearch(keyringID, "user", c.description(id), 0)
+	if err != nil {
+		if errors.Is(err, unix.ENOKEY) || errors.Is(err, unix.EKEYEXPIRED) || errors.Is(err, unix.EKEYREVOKED) {
+			return nil, nil
+		}
+		return nil, keyctlError("get", err)
+	}
+
+	key, err := k.read(keyID)
+	if err != nil {
+		return nil, keyctlError("get", err)
+	}
+
+	return key, nil
+}
+
+func (c keyctlKeyCache) store(id string, key []byte) error {
+	k := c.keyctl
+	keyringID, err := k.getKeyring()
+	if err != nil {
+		return keyctlError("set", err)
+	}
+
+	if _, _, err := k.store(keyringID, c.description(id), key); err != nil {
+		return keyctlError("set", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+//go:build linux
+
+package keyring
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestHybridFileProvider(t *testing.T) {
+	setTempFileDirs(t)
+
+	prompts := 0
+	recovery := func() ([]byte, error) {
+		prompts++
+		return []byte("recovery"), nil
+	}
+
+	keyctl := newTestKeyctlProvider(t)
+	provider := newHybridFileProvider(recovery, keyctl)
+	if err := provider.Set(service, user, password); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	id := provider.crypter.id
+
+	keyringID, err := keyctl.getKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlinkKey := func() {
+		keyID, err := unix.KeyctlSearch(keyringID, "user", keyctlKeyCache{}.description(id), 0)
+		if err == nil {
+			_, _ = unix.KeyctlInt(unix.KEYCTL_UNLINK, keyID, keyringID, 0, 0)
+		}
+	}
+	defer unlinkKey()
+
+	// while the key is in the kernel keyring, no passphrase is needed
+	noPassphrase := func() ([]byte, error) {
+		return nil, errors.New("unexpected passphrase prompt")
+	}
+	pw, err := newHybridFileProvider(noPassphrase, keyctl).Get(service, user)
+	if err != nil || pw != password {
+		t.Fatalf("Expected password %s, got %q and %v", password, pw, err)
+	}
+
+	// after a reboot, the recovery passphrase re-seals the key once
+	unlinkKey()
+	prompts = 0
+
+	pw, err = newHybridFileProvider(recovery, keyctl).Get(service, user)
+	if err != nil || pw != password {
+		t.Fatalf("Expected password %s, got %q and %v", password, pw, err)
+	}
+
+	pw, err = newHybridFileProvider(noPassphrase, keyctl).Get(service, user)
+	if err != nil || pw != password {
+		t.Fatalf("Expected password %s, got %q and %v", password, pw, err)
+	}
+
+	if prompts != 1 {
+		t.Errorf("Expected one prompt for the recovery passphrase, got %d", prompts)
+	}
+
+	// the encrypted file backend reads the same files
+	pw, err = newEncryptedFileProvider(recovery).Get(service, user)
+	if err != nil || pw != password {
+		t.Errorf("Expected password %s, got %q and %v", password, pw, err)
+	}
+}
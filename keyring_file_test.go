@@ -0,0 +1,471 @@
+//go:build linux
+
+package keyring
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	osuser "os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// setTempFileDirs points the config and state directories, and thereby the
+// file backends, to temporary directories.
+func setTempFileDirs(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv(FileDirEnv, "")
+}
+
+func TestFileProviderItem(t *testing.T) {
+	setTempFileDirs(t)
+	provider := &fileProvider{}
+
+	// secrets without metadata still report when they were written
+	if err := provider.Set(service, user, password); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	err := provider.SetItem(service, user, Item{
+		Label:      "label",
+		Attributes: map[string]string{"host": "example.com", "service": "ignored"},
+		Secret:     []byte("secret"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to set item: %v", err)
+	}
+
+	item, err := provider.GetItem(service, user)
+	if err != nil {
+		t.Fatalf("Failed to get item: %v", err)
+	}
+	if item.Label != "label" || len(item.Attributes) != 1 || item.Attributes["host"] != "example.com" {
+		t.Errorf("Expected label and attributes to be kept, got %+v", item)
+	}
+	if item.Created.IsZero() || item.Modified.Before(item.Created) {
+		t.Errorf("Expected created and modified times, got %s and %s", item.Created, item.Modified)
+	}
+
+	users, err := provider.List(service)
+	if err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+	if len(users) != 1 || users[0] != user {
+		t.Errorf("Expected users [%s], got %v", user, users)
+	}
+
+	if err := provider.Delete(service, user); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+
+	_, err = provider.GetItem(service, user)
+	assertError(t, err, ErrNotFound)
+}
+
+func TestFileProviderSetWithTTL(t *testing.T) {
+	setTempFileDirs(t)
+	provider := &fileProvider{}
+
+	if err := provider.SetWithTTL(service, user, password, 10*time.Millisecond); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	pw, err := provider.Get(service, user)
+	if err != nil {
+		t.Fatalf("Failed to get password: %v", err)
+	}
+	if pw != password {
+		t.Errorf("Expected password %s, got %s", password, pw)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	_, err = provider.Get(service, user)
+	assertError(t, err, ErrNotFound)
+
+	// the expired secret was removed
+	tokenPath, _, err := provider.paths(service, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tokenPath); !os.IsNotExist(err) {
+		t.Errorf("Expected token file to be removed, got %v", err)
+	}
+}
+
+func TestFileProviderTraversal(t *testing.T) {
+	setTempFileDirs(t)
+	provider := &fileProvider{}
+
+	err := provider.Set(service, "../../.bashrc", password)
+	if !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Expected ErrInvalidName, got %v", err)
+	}
+
+	err = provider.Set("..", user, password)
+	if !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Expected ErrInvalidName, got %v", err)
+	}
+
+	// separators and other special characters are stored encoded
+	for _, name := range []string{"a/b", ".meta", "", "a b"} {
+		if err := provider.Set(name, name, password); err != nil {
+			t.Fatalf("Failed to set password for %q: %v", name, err)
+		}
+
+		users, err := provider.List(name)
+		if err != nil {
+			t.Fatalf("Failed to list %q: %v", name, err)
+		}
+		if len(users) != 1 || users[0] != name {
+			t.Errorf("Expected users [%q], got %q", name, users)
+		}
+	}
+}
+
+// TestFileProviderConfigService tests that the legacy directory of a service
+// named "config" at the path of the config file keeps working.
+func TestFileProviderConfigService(t *testing.T) {
+	setTempFileDirs(t)
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+
+	legacyPath := filepath.Join(configDir, filepath.FromSlash(configFile), user)
+	if err := os.MkdirAll(filepath.Dir(legacyPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(legacyPath, []byte(password), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := loadDetectConfig()
+	if err != nil || cfg.backends != nil {
+		t.Errorf("Expected the service directory to be ignored as config file, got %v and %v", cfg.backends, err)
+	}
+
+	provider := &fileProvider{}
+	pw, err := provider.Get("config", user)
+	if err != nil || pw != password {
+		t.Fatalf("Expected password %s, got %q and %v", password, pw, err)
+	}
+
+	// once migrated, the path is free for the config file
+	if err := provider.Set("config", user, "new"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, filepath.FromSlash(configFile)), []byte("backends = file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pw, err = provider.Get("config", user)
+	if err != nil || pw != "new" {
+		t.Fatalf("Expected password new, got %q and %v", pw, err)
+	}
+	users, err := provider.List("config")
+	if err != nil || len(users) != 1 || users[0] != user {
+		t.Errorf("Expected users [%s], got %v and %v", user, users, err)
+	}
+	if err := provider.DeleteAll("config"); err != nil {
+		t.Errorf("Failed to delete service: %v", err)
+	}
+
+	cfg, err = loadDetectConfig()
+	if err != nil || len(cfg.backends) != 1 || cfg.backends[0] != "file" {
+		t.Errorf("Expected backends [file] from the config file, got %v and %v", cfg.backends, err)
+	}
+}
+
+func TestFileProviderLongName(t *testing.T) {
+	setTempFileDirs(t)
+	provider := &fileProvider{}
+
+	// 200 bytes, which percent-escaping triples
+	long := strings.Repeat("ü", 100)
+	if err := provider.Set(service, long, password); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Expected ErrInvalidName, got %v", err)
+	}
+	if err := provider.Set(long, user, password); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Expected ErrInvalidName, got %v", err)
+	}
+
+	// the longest name which fits into a file name
+	longest := strings.Repeat("a", maxFileNameLen-len(fileNamePrefix))
+	if err := provider.Set(service, longest, password); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if pw, err := provider.Get(service, longest); err != nil || pw != password {
+		t.Errorf("Expected password %s, got %q and %v", password, pw, err)
+	}
+}
+
+func TestFileProviderLegacyLayout(t *testing.T) {
+	setTempFileDirs(t)
+	provider := &fileProvider{}
+
+	root, err := provider.root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyPath := filepath.Join(root, service, user)
+	if err := os.MkdirAll(filepath.Dir(legacyPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(legacyPath, []byte(password), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pw, err := provider.Get(service, user)
+	if err != nil || pw != password {
+		t.Fatalf("Expected password %s, got %q and %v", password, pw, err)
+	}
+
+	users, err := provider.List(service)
+	if err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+	if len(users) != 1 || users[0] != user {
+		t.Errorf("Expected users [%s], got %v", user, users)
+	}
+
+	// setting the secret migrates it
+	if err := provider.Set(service, user, "new"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, service)); !os.IsNotExist(err) {
+		t.Errorf("Expected legacy directory to be removed, got %v", err)
+	}
+
+	pw, err = provider.Get(service, user)
+	if err != nil || pw != "new" {
+		t.Fatalf("Expected password new, got %q and %v", pw, err)
+	}
+}
+
+// hammerFileProvider sets and reads a secret shared by all callers and one of
+// its own, checking that no partial writes are observed. id must have two
+// characters.
+func hammerFileProvider(t *testing.T, id string) {
+	provider := &fileProvider{}
+	value := strings.Repeat(id, 4096)
+
+	for i := 0; i < 50; i++ {
+		if err := provider.Set(service, "shared", value); err != nil {
+			t.Errorf("Failed to set shared password: %v", err)
+			return
+		}
+		if err := provider.Set(service, user+id, value); err != nil {
+			t.Errorf("Failed to set password: %v", err)
+			return
+		}
+
+		pw, err := provider.Get(service, "shared")
+		if err != nil {
+			t.Errorf("Failed to get shared password: %v", err)
+			return
+		}
+		if len(pw) != len(value) || pw != strings.Repeat(pw[:2], 4096) {
+			t.Errorf("Expected a complete password, got %d bytes", len(pw))
+			return
+		}
+	}
+}
+
+// TestFileProviderHammerProcess is run by TestFileProviderConcurrent in
+// separate processes.
+func TestFileProviderHammerProcess(t *testing.T) {
+	id := os.Getenv("GO_KEYRING_TEST_HAMMER")
+	if id == "" {
+		t.Skip("only run by TestFileProviderConcurrent")
+	}
+	hammerFileProvider(t, id)
+}
+
+func TestFileProviderConcurrent(t *testing.T) {
+	setTempFileDirs(t)
+
+	var wg sync.WaitGroup
+	var ids []string
+
+	for i := 0; i < 4; i++ {
+		id := fmt.Sprintf("p%d", i)
+		ids = append(ids, id)
+
+		cmd := exec.Command(os.Args[0], "-test.run=^TestFileProviderHammerProcess$")
+		cmd.Env = append(os.Environ(), "GO_KEYRING_TEST_HAMMER="+id)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("Process %s failed: %v\n%s", id, err, out)
+			}
+		}()
+	}
+
+	for i := 0; i < 8; i++ {
+		id := fmt.Sprintf("g%d", i)
+		ids = append(ids, id)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hammerFileProvider(t, id)
+		}()
+	}
+
+	wg.Wait()
+
+	users, err := (&fileProvider{}).List(service)
+	if err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+	if len(users) != len(ids)+1 {
+		t.Errorf("Expected %d users, got %v", len(ids)+1, users)
+	}
+}
+
+func TestFileProviderDir(t *testing.T) {
+	setTempFileDirs(t)
+
+	// secrets stored below the config directory by older versions are kept
+	// there
+	configRoot := filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "go-keyring")
+	if root, err := (&fileProvider{}).root(); err != nil || root != filepath.Join(os.Getenv("XDG_STATE_HOME"), "go-keyring") {
+		t.Errorf("Expected root below the state directory, got %s and %v", root, err)
+	}
+	if err := os.MkdirAll(filepath.Join(configRoot, service), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if root, err := (&fileProvider{}).root(); err != nil || root != configRoot {
+		t.Errorf("Expected root %s, got %s and %v", configRoot, root, err)
+	}
+
+	dir := t.TempDir()
+	t.Setenv(FileDirEnv, dir)
+	if root, err := (&fileProvider{}).root(); err != nil || root != filepath.Join(dir, "go-keyring") {
+		t.Errorf("Expected root below %s, got %s and %v", dir, root, err)
+	}
+
+	dir = t.TempDir()
+	kr, err := New(WithFile(), WithFileDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kr.Set(service, user, password); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "go-keyring", encodeFileName(service), encodeFileName(user))); err != nil {
+		t.Errorf("Expected secret below %s: %v", dir, err)
+	}
+}
+
+func TestFileProviderPermissions(t *testing.T) {
+	setTempFileDirs(t)
+	provider := &fileProvider{}
+
+	if err := provider.Set(service, user, password); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	tokenPath, _, err := provider.paths(service, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path string
+		mode os.FileMode
+	}{
+		{tokenPath, 0644},
+		{filepath.Dir(tokenPath), 0755},
+		{filepath.Dir(filepath.Dir(tokenPath)), 0750},
+	} {
+		if err := os.Chmod(tc.path, tc.mode); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := provider.Get(service, user)
+		if !errors.Is(err, ErrInsecurePermissions) {
+			t.Errorf("Expected ErrInsecurePermissions for mode %04o of %s, got %v", tc.mode, tc.path, err)
+		}
+
+		pw, err := (&fileProvider{repair: true}).Get(service, user)
+		if err != nil || pw != password {
+			t.Fatalf("Expected password %s after repair, got %q and %v", password, pw, err)
+		}
+	}
+
+	info, err := os.Stat(tokenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected repaired mode 0600, got %04o", info.Mode().Perm())
+	}
+}
+
+func TestFileProviderUID(t *testing.T) {
+	setTempFileDirs(t)
+
+	nobody, err := osuser.Lookup("nobody")
+	if err != nil {
+		t.Skip("no user nobody")
+	}
+	uid, err := strconv.Atoi(nobody.Uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without a directory, the home directory of the user is used
+	home := t.TempDir()
+	if root, err := (&fileProvider{owner: &fileOwner{uid: uid, home: home}}).root(); err != nil || root != filepath.Join(home, ".local", "state", "go-keyring") {
+		t.Errorf("Expected root below the home directory, got %q and %v", root, err)
+	}
+
+	dir := t.TempDir()
+	kr, err := New(WithEncryptedFile(staticPassphrase("passphrase")), WithFileDir(dir), WithUID(uid))
+	if os.Geteuid() != 0 {
+		if !errors.Is(err, ErrAccessDenied) {
+			t.Errorf("Expected ErrAccessDenied without privileges, got %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Failed to open keyring: %v", err)
+	}
+
+	if err := kr.Set(service, user, password); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if pw, err := kr.Get(service, user); err != nil || pw != password {
+		t.Errorf("Expected password %s, got %q and %v", password, pw, err)
+	}
+
+	root := filepath.Join(dir, "go-keyring-encrypted")
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if st := info.Sys().(*syscall.Stat_t); int(st.Uid) != uid {
+			t.Errorf("Expected %s to be owned by uid %d, got %d", path, uid, st.Uid)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the caller refuses the files of the other user
+	other := newEncryptedFileProvider(staticPassphrase("passphrase"))
+	other.dir = dir
+	if _, err := other.Get(service, user); !errors.Is(err, ErrInsecurePermissions) {
+		t.Errorf("Expected ErrInsecurePermissions for files of another user, got %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+//go:build linux
+
+package keyring
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fileNamePrefix marks file names produced by encodeFileName, telling them
+// apart from the raw names used before. As it isn't a dot, encoded names
+// never clash with the hidden files of the file backends, e.g. ".meta".
+const fileNamePrefix = "="
+
+// maxFileNameLen is the longest file name supported by common filesystems,
+// NAME_MAX on Linux.
+const maxFileNameLen = 255
+
+// encodeFileName encodes a service or user as a single path element. All
+// bytes except ASCII letters, digits, '-', '_' and '.' are percent-escaped,
+// so the result is valid on any filesystem and never contains a separator.
+func encodeFileName(name string) string {
+	var b strings.Builder
+	b.WriteString(fileNamePrefix)
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; isFileNameByte(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// decodeFileName reverses encodeFileName. ok is false if fileName wasn't
+// produced by encodeFileName.
+func decodeFileName(fileName string) (name string, ok bool) {
+	if !strings.HasPrefix(fileName, fileNamePrefix) {
+		return "", false
+	}
+	fileName = strings.TrimPrefix(fileName, fileNamePrefix)
+
+	b := make([]byte, 0, len(fileName))
+	for i := 0; i < len(fileName); i++ {
+		c := fileName[i]
+		switch {
+		case isFileNameByte(c):
+			b = append(b, c)
+		case c == '%' && i+2 < len(fileName) && isUpperHex(fileName[i+1]) && isUpperHex(fileName[i+2]):
+			// only the escapes produced by encodeFileName, so that every name
+			// has a single encoding
+			c = unhex(fileName[i+1])<<4 | unhex(fileName[i+2])
+			if isFileNameByte(c) {
+				return "", false
+			}
+			b = append(b, c)
+			i += 2
+		default:
+			return "", false
+		}
+	}
+	return string(b), true
+}
+
+// checkFileName returns ErrInvalidName if name has a ".." element, which
+// would point outside of its directory in the raw layout used before
+// encodeFileName. Such names are rejected rather than stored encoded, as they
+// hint at an attempted path traversal. So are names whose encoding exceeds
+// maxFileNameLen, which the filesystem would refuse.
+func checkFileName(name string) error {
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return kindError{ErrInvalidName, fmt.Errorf("%q traverses outside of the keyring", name)}
+		}
+	}
+	if n := len(encodeFileName(name)); n > maxFileNameLen {
+		return kindError{ErrInvalidName, fmt.Errorf("%q is encoded as file name of %d bytes, longer than %d", name, n, maxFileNameLen)}
+	}
+	return nil
+}
+
+func isFileNameByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.'
+}
+
+func isUpperHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	if c <= '9' {
+		return c - '0'
+	}
+	return c - 'A' + 10
+}
@@ -0,0 +1,30 @@
+//go:build linux
+
+package keyring
+
+import "testing"
+
+func TestEncodeFileName(t *testing.T) {
+	for _, name := range []string{"user", "", ".", "..", ".meta", "a/b", "100%", "%2F", "ü", "a\x00b"} {
+		fileName := encodeFileName(name)
+		for _, c := range []byte(fileName) {
+			if c == '/' || c == 0 {
+				t.Errorf("Expected %q to be a single path element", fileName)
+			}
+		}
+		if fileName[0] == '.' {
+			t.Errorf("Expected %q not to be hidden", fileName)
+		}
+
+		decoded, ok := decodeFileName(fileName)
+		if !ok || decoded != name {
+			t.Errorf("Expected %q to decode to %q, got %q and %v", fileName, name, decoded, ok)
+		}
+	}
+
+	for _, fileName := range []string{"user", ".tmp-1", "=a/b", "=%2f", "=%2", "=%41"} {
+		if _, ok := decodeFileName(fileName); ok {
+			t.Errorf("Expected %q not to decode", fileName)
+		}
+	}
+}
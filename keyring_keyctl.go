@@ -3,135 +3,1220 @@
 package keyring
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"os/exec"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"golang.org/x/sys/cpu"
 	"golang.org/x/sys/unix"
 )
 
-type keyctlProvider struct{}
+// KeyctlKeyring names a kernel keyring the keyctl backend can store secrets
+// in, see keyrings(7).
+type KeyctlKeyring string
+
+const (
+	// KeyctlPersistent is the user's persistent keyring, which survives
+	// logout and is dropped a few days after its last use. It's the default.
+	// On kernels without persistent keyrings, KeyctlUser is used instead.
+	KeyctlPersistent KeyctlKeyring = "persistent"
+	// KeyctlUser is the user keyring, shared by all processes of the user
+	// until the last one exits.
+	KeyctlUser KeyctlKeyring = "user"
+	// KeyctlUserSession is the user's default session keyring.
+	KeyctlUserSession KeyctlKeyring = "user-session"
+	// KeyctlSession is the session keyring of the process, shared with the
+	// processes it starts.
+	KeyctlSession KeyctlKeyring = "session"
+	// KeyctlProcess is the keyring of the current process.
+	KeyctlProcess KeyctlKeyring = "process"
+	// KeyctlThread is the keyring of the current thread. As goroutines move
+	// between threads, it's only useful together with runtime.LockOSThread.
+	KeyctlThread KeyctlKeyring = "thread"
+)
+
+// KeyctlKeyringID selects the existing keyring with the given ID, e.g. a
+// private keyring created by the caller. Unlike for the other keyrings, the
+// caller has to make sure the keyring is possessed, for example by linking it
+// into its session keyring, unless the permission mask grants the user enough
+// rights.
+func KeyctlKeyringID(id int) KeyctlKeyring {
+	return KeyctlKeyring(strconv.Itoa(id))
+}
+
+// id returns the ID of a keyring selected by KeyctlKeyringID.
+func (k KeyctlKeyring) id() (int, bool) {
+	id, err := strconv.Atoi(string(k))
+	return id, err == nil && id > 0
+}
+
+// keyctlSpecialKeyrings maps the keyrings besides KeyctlPersistent to their
+// special IDs.
+var keyctlSpecialKeyrings = map[KeyctlKeyring]int{
+	KeyctlUser:        unix.KEY_SPEC_USER_KEYRING,
+	KeyctlUserSession: unix.KEY_SPEC_USER_SESSION_KEYRING,
+	KeyctlSession:     unix.KEY_SPEC_SESSION_KEYRING,
+	KeyctlProcess:     unix.KEY_SPEC_PROCESS_KEYRING,
+	KeyctlThread:      unix.KEY_SPEC_THREAD_KEYRING,
+}
+
+// KeyctlPerm is the permission mask of a kernel key. It holds a byte of
+// KeyctlPermView to KeyctlPermSetattr for each of the possessor, the owner,
+// the group and others, from the most to the least significant byte.
+type KeyctlPerm uint32
+
+// Permissions of a single class, see KeyctlPermMask.
+const (
+	KeyctlPermView KeyctlPerm = 1 << iota
+	KeyctlPermRead
+	KeyctlPermWrite
+	KeyctlPermSearch
+	KeyctlPermLink
+	KeyctlPermSetattr
+)
+
+// keyctlPermAll is the union of the permissions of a single class.
+const keyctlPermAll = KeyctlPermView | KeyctlPermRead | KeyctlPermWrite | KeyctlPermSearch | KeyctlPermLink | KeyctlPermSetattr
+
+// DefaultKeyctlPerm is the permission mask the keyctl backend applies to the
+// keys and keyrings it creates, granting the possessor only what the backend
+// needs. Unlike the kernel's default, it doesn't let the keys be linked
+// elsewhere and hides them from processes which don't possess them.
+const DefaultKeyctlPerm = keyctlPermRequired << 24
+
+// keyctlPermRequired are the permissions the keyctl backend needs on its keys
+// and keyrings, which every mask must grant the possessor.
+const keyctlPermRequired = KeyctlPermView | KeyctlPermRead | KeyctlPermWrite | KeyctlPermSearch | KeyctlPermSetattr
+
+// KeyctlPermMask returns the mask granting possessor, user, group and other
+// the given permissions.
+func KeyctlPermMask(possessor, user, group, other KeyctlPerm) KeyctlPerm {
+	return (possessor&keyctlPermAll)<<24 | (user&keyctlPermAll)<<16 | (group&keyctlPermAll)<<8 | other&keyctlPermAll
+}
+
+// Possessor returns the permissions granted to processes possessing the key.
+func (p KeyctlPerm) Possessor() KeyctlPerm { return p >> 24 & keyctlPermAll }
+
+// User returns the permissions granted to the owner of the key.
+func (p KeyctlPerm) User() KeyctlPerm { return p >> 16 & keyctlPermAll }
+
+// Group returns the permissions granted to the group of the key.
+func (p KeyctlPerm) Group() KeyctlPerm { return p >> 8 & keyctlPermAll }
+
+// Other returns the permissions granted to everybody else.
+func (p KeyctlPerm) Other() KeyctlPerm { return p & keyctlPermAll }
+
+// String formats the mask in hex, as keyctl(1) does.
+func (p KeyctlPerm) String() string {
+	return fmt.Sprintf("%08x", uint32(p))
+}
+
+// KeyctlKeyInfo describes a kernel key as reported by KEYCTL_DESCRIBE.
+type KeyctlKeyInfo struct {
+	ID          int
+	Type        string
+	UID         int
+	GID         int
+	Perm        KeyctlPerm
+	Description string
+}
+
+// KeyctlInspector is implemented by keyrings of the keyctl backend, to audit
+// the keys they store secrets in.
+type KeyctlInspector interface {
+	// InspectKeyring describes the keyring holding the keyrings of the
+	// services.
+	InspectKeyring() (KeyctlKeyInfo, error)
+	// InspectService describes the keyring holding the secrets of service.
+	InspectService(service string) (KeyctlKeyInfo, error)
+	// Inspect describes the key holding the secret of service and user,
+	// followed by the key holding its metadata if there is one.
+	Inspect(service, user string) ([]KeyctlKeyInfo, error)
+	// Quota returns the usage of the user's key quota.
+	Quota() (KeyctlQuota, error)
+}
+
+// KeyctlQuota is the usage of the kernel's per-user key quota, see
+// /proc/key-users in keyrings(7). Once either limit is reached, storing
+// secrets fails with ErrQuotaExceeded.
+type KeyctlQuota struct {
+	// Keys is the number of keys owned by the user, MaxKeys the limit.
+	Keys    int
+	MaxKeys int
+	// Bytes is the size of the payloads of the keys owned by the user,
+	// MaxBytes the limit.
+	Bytes    int
+	MaxBytes int
+}
+
+func (q KeyctlQuota) String() string {
+	return fmt.Sprintf("%d/%d keys, %d/%d bytes", q.Keys, q.MaxKeys, q.Bytes, q.MaxBytes)
+}
+
+type keyctlProvider struct {
+	// keyring is the keyring holding the secrets, KeyctlPersistent if empty.
+	keyring KeyctlKeyring
+	// name is the description of a keyring linked into keyring which holds
+	// the secrets instead, if set.
+	name string
+	// perm is the permission mask of created keys, DefaultKeyctlPerm if 0.
+	perm KeyctlPerm
+	// uid is the user whose persistent keyring holds the secrets and who
+	// owns created keys, see WithUID. It's the caller if nil.
+	uid *int
+}
+
+// metaKeyPrefix is prepended to the description of a secret's key to get the
+// description of the key holding its metadata.
+const metaKeyPrefix = "go-keyring-meta:"
 
 func init() {
-	fileFallback := &fileProvider{}
-	getFallbackProvider = func() Keyring {
-		return compositeProvider{
-			primary:  keyctlProvider{},
-			fallback: fileFallback,
+	Register("keyctl", func(cfg Config) (Keyring, error) {
+		var perm uint64
+		if v := cfg.Options["perm"]; v != "" {
+			var err error
+			if perm, err = strconv.ParseUint(v, 0, 32); err != nil {
+				return nil, keyctlError("open", fmt.Errorf("invalid permission mask %q", v))
+			}
+		}
+		var uid *int
+		if v := cfg.Options["uid"]; v != "" {
+			id, err := lookupUID(v)
+			if err != nil {
+				return nil, keyctlError("open", err)
+			}
+			uid = &id
+		}
+		k, err := newKeyctlProvider(KeyctlKeyring(cfg.Options["keyring"]), cfg.Options["keyring-name"], KeyctlPerm(perm), uid)
+		if err != nil {
+			return nil, keyctlError("open", err)
+		}
+		if _, err := k.getKeyring(); err != nil {
+			return nil, keyctlError("open", err)
+		}
+		return k, nil
+	})
+}
+
+// WithKeyctl selects the Linux kernel keyring backend, see WithKeyctlKeyring
+// for the keyring it uses.
+func WithKeyctl() Option {
+	return func(o *options) {
+		o.open = func() (Keyring, error) {
+			k, err := newKeyctlProvider(KeyctlKeyring(o.keyctlKeyring), o.keyctlKeyringName, KeyctlPerm(o.keyctlPerm), o.uid)
+			if err != nil {
+				return nil, keyctlError("open", err)
+			}
+			return k, nil
+		}
+	}
+}
+
+// WithKeyctlKeyring sets the kernel keyring the keyctl backend stores secrets
+// in, KeyctlPersistent by default. If name isn't empty, the secrets are kept
+// in a keyring with that description linked into keyring, which is created
+// if needed.
+//
+// It only applies together with WithKeyctl or WithHybridFile. Backends opened
+// by name take the "keyring" and "keyring-name" options instead.
+func WithKeyctlKeyring(keyring KeyctlKeyring, name string) Option {
+	return func(o *options) {
+		o.keyctlKeyring = string(keyring)
+		o.keyctlKeyringName = name
+	}
+}
+
+// WithKeyctlPerm sets the permission mask the keyctl backend applies to the
+// keys and keyrings it creates, DefaultKeyctlPerm by default. The mask must
+// grant the possessor view, read, write, search and setattr permission.
+// Existing keys keep their permissions when they are updated.
+//
+// It only applies together with WithKeyctl. Backends opened by name take the
+// "perm" option instead, e.g. "0x3f000000".
+func WithKeyctlPerm(perm KeyctlPerm) Option {
+	return func(o *options) {
+		o.keyctlPerm = uint32(perm)
+	}
+}
+
+func newKeyctlProvider(keyring KeyctlKeyring, name string, perm KeyctlPerm, uid *int) (keyctlProvider, error) {
+	if _, ok := keyctlSpecialKeyrings[keyring]; ok {
+		// the special keyrings are those of the caller
+		if uid != nil {
+			return keyctlProvider{}, fmt.Errorf("the %s keyring can't hold the secrets of uid %d", keyring, *uid)
+		}
+	} else if keyring != "" && keyring != KeyctlPersistent {
+		if _, ok := keyring.id(); !ok {
+			return keyctlProvider{}, fmt.Errorf("unknown keyring %q", keyring)
+		}
+	}
+	if perm != 0 && perm.Possessor()&keyctlPermRequired != keyctlPermRequired {
+		return keyctlProvider{}, fmt.Errorf("permission mask %s doesn't grant the possessor view, read, write, search and setattr", perm)
+	}
+	return keyctlProvider{keyring: keyring, name: name, perm: perm, uid: uid}, nil
+}
+
+// targetUID returns the uid of the user whose secrets are stored.
+func (k keyctlProvider) targetUID() int {
+	if k.uid == nil {
+		return os.Getuid()
+	}
+	return *k.uid
+}
+
+// permMask returns the permission mask of created keys.
+func (k keyctlProvider) permMask() KeyctlPerm {
+	if k.perm == 0 {
+		return DefaultKeyctlPerm
+	}
+	return k.perm
+}
+
+// restrict applies the permission mask to the newly created key with the
+// given ID, and gives it to the user of another user's secrets. If that
+// fails, the key is unlinked from the keyring again, so it isn't left with
+// the kernel's default permissions or owner.
+func (k keyctlProvider) restrict(keyringID, keyID int) error {
+	if err := unix.KeyctlSetperm(keyID, uint32(k.permMask())); err != nil {
+		_, _ = unix.KeyctlInt(unix.KEYCTL_UNLINK, keyID, keyringID, 0, 0)
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+
+	if k.uid != nil {
+		if _, err := unix.KeyctlInt(unix.KEYCTL_CHOWN, keyID, *k.uid, -1, 0); err != nil {
+			_, _ = unix.KeyctlInt(unix.KEYCTL_UNLINK, keyID, keyringID, 0, 0)
+			if errors.Is(err, unix.EACCES) || errors.Is(err, unix.EPERM) {
+				return fmt.Errorf("giving keys to uid %d requires CAP_SYS_ADMIN: %w", *k.uid, err)
+			}
+			return fmt.Errorf("failed to give key to uid %d: %w", *k.uid, k.quotaError(err))
+		}
+	}
+	return nil
+}
+
+// getKeyring returns the ID of the keyring holding the secrets, creating it
+// if needed.
+func (k keyctlProvider) getKeyring() (int, error) {
+	keyringID, err := k.getBaseKeyring()
+	if err != nil || k.name == "" {
+		return keyringID, err
+	}
+
+	namedID, err := unix.KeyctlSearch(keyringID, "keyring", k.name, 0)
+	if errors.Is(err, unix.ENOKEY) {
+		namedID, err = unix.AddKey("keyring", k.name, nil, keyringID)
+		if err == nil {
+			err = k.restrict(keyringID, namedID)
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get keyring %q: %w", k.name, err)
+	}
+	return namedID, nil
+}
+
+// getBaseKeyring returns the ID of the keyring selected by k.keyring. The
+// persistent keyring falls back to the user keyring if the kernel doesn't
+// support persistent keyrings.
+func (k keyctlProvider) getBaseKeyring() (int, error) {
+	keyring := k.keyring
+	if keyring == "" || keyring == KeyctlPersistent {
+		uid := -1
+		if k.uid != nil {
+			uid = *k.uid
+		}
+		keyringID, err := unix.KeyctlInt(unix.KEYCTL_GET_PERSISTENT, uid, unix.KEY_SPEC_SESSION_KEYRING, 0, 0)
+		if err == nil {
+			return keyringID, nil
+		}
+		switch {
+		case k.uid != nil && errors.Is(err, unix.EPERM):
+			return 0, fmt.Errorf("getting the persistent keyring of uid %d requires CAP_SETUID: %w", uid, err)
+		case k.uid != nil || !errors.Is(err, unix.EOPNOTSUPP):
+			return 0, fmt.Errorf("failed to get persistent keyring: %w", err)
+		}
+		keyring = KeyctlUser
+	}
+
+	if id, ok := keyring.id(); ok {
+		keyringID, err := unix.KeyctlGetKeyringID(id, false)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get keyring %d: %w", id, err)
 		}
+		return keyringID, nil
+	}
+
+	// resolve the special ID, creating the keyring if needed
+	keyringID, err := unix.KeyctlGetKeyringID(keyctlSpecialKeyrings[keyring], true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get %s keyring: %w", keyring, err)
+	}
+
+	// most permissions on the user keyrings are granted to possessors only,
+	// so link them into the session keyring like the persistent keyring. This
+	// fails harmlessly if the session keyring is the user session keyring.
+	if keyring == KeyctlUser || keyring == KeyctlUserSession {
+		_, _ = unix.KeyctlInt(unix.KEYCTL_LINK, keyringID, unix.KEY_SPEC_SESSION_KEYRING, 0, 0)
 	}
+
+	return keyringID, nil
+}
+
+// serviceKeyringName returns the description of the keyring holding the
+// secrets of service.
+func serviceKeyringName(service string) string {
+	return keyNamePrefix + keyNameEscaper.Replace(service)
 }
 
-// getPersistentKeyring gets or creates the persistent keyring for the current user.
-func (k keyctlProvider) getPersistentKeyring() (int, error) {
-	persistentKeyringID, err := unix.KeyctlInt(unix.KEYCTL_GET_PERSISTENT, -1, unix.KEY_SPEC_SESSION_KEYRING, 0, 0)
+// serviceKeyring returns the ID of the keyring holding the secrets of
+// service, which is linked into the keyring with the ID keyringID. If there is
+// none, it's created if create is set and ENOKEY is returned otherwise.
+func (k keyctlProvider) serviceKeyring(keyringID int, service string, create bool) (int, error) {
+	name := serviceKeyringName(service)
+	serviceID, err := unix.KeyctlSearch(keyringID, "keyring", name, 0)
+	if !errors.Is(err, unix.ENOKEY) || !create {
+		return serviceID, err
+	}
+
+	serviceID, err = unix.AddKey("keyring", name, nil, keyringID)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get persistent keyring: %w", err)
+		return 0, fmt.Errorf("failed to create keyring of service %q: %w", service, k.quotaError(err))
 	}
-	return persistentKeyringID, nil
+	if err := k.restrict(keyringID, serviceID); err != nil {
+		return 0, err
+	}
+	return serviceID, nil
+}
+
+// keyctlError maps err returned by a keyctl syscall during op onto the errors
+// of this package. Expired and revoked keys are deliberately reported as
+// ErrNotFound: the kernel's key timeout implements SetWithTTL, whose expired
+// secrets are missing on every backend, and a revoked key can't be read or
+// updated any more, just like a deleted one.
+func keyctlError(op string, err error) error {
+	switch {
+	case errors.Is(err, unix.ENOKEY),
+		errors.Is(err, unix.EKEYEXPIRED),
+		errors.Is(err, unix.EKEYREVOKED):
+		return ErrNotFound
+	case errors.Is(err, unix.EACCES),
+		errors.Is(err, unix.EPERM):
+		err = kindError{ErrAccessDenied, err}
+	case errors.Is(err, unix.ENOSYS),
+		errors.Is(err, unix.EOPNOTSUPP):
+		err = kindError{ErrBackendUnavailable, err}
+	case errors.Is(err, unix.EDQUOT):
+		err = kindError{ErrQuotaExceeded, err}
+	}
+	return newBackendError("keyctl", op, err)
+}
+
+// Quota returns the usage of the user's key quota.
+func (k keyctlProvider) Quota() (KeyctlQuota, error) {
+	quota, err := readKeyctlQuota(k.targetUID())
+	return quota, keyctlError("quota", err)
+}
+
+// quotaError adds the usage of the user's key quota to err if it's EDQUOT.
+func (k keyctlProvider) quotaError(err error) error {
+	if !errors.Is(err, unix.EDQUOT) {
+		return err
+	}
+	quota, quotaErr := readKeyctlQuota(k.targetUID())
+	if quotaErr != nil {
+		return err
+	}
+	return fmt.Errorf("%w (used %s)", err, quota)
+}
+
+// readKeyctlQuota reads the key quota usage of the user with the given uid.
+// Users without keys aren't listed in /proc/key-users, so their limits are
+// read from the sysctls.
+func readKeyctlQuota(uid int) (KeyctlQuota, error) {
+	data, err := os.ReadFile("/proc/key-users")
+	if err != nil {
+		return KeyctlQuota{}, err
+	}
+
+	quota, ok, err := parseKeyUsers(data, uid)
+	if err != nil || ok {
+		return quota, err
+	}
+
+	// root has separate limits
+	prefix := "/proc/sys/kernel/keys/"
+	if uid == 0 {
+		prefix += "root_"
+	}
+	if quota.MaxKeys, err = readSysctlInt(prefix + "maxkeys"); err != nil {
+		return KeyctlQuota{}, err
+	}
+	if quota.MaxBytes, err = readSysctlInt(prefix + "maxbytes"); err != nil {
+		return KeyctlQuota{}, err
+	}
+	return quota, nil
+}
+
+// parseKeyUsers returns the quota usage of the user with the given uid from
+// the contents of /proc/key-users, with lines of the form
+// "uid: usage nkeys/ninstantiated qnkeys/maxkeys qnbytes/maxbytes". ok is
+// false if the user isn't listed.
+func parseKeyUsers(data []byte, uid int) (quota KeyctlQuota, ok bool, err error) {
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 5 || fields[0] != strconv.Itoa(uid)+":" {
+			continue
+		}
+
+		keys, maxKeys, keysOK := strings.Cut(fields[3], "/")
+		bytes, maxBytes, bytesOK := strings.Cut(fields[4], "/")
+		values := make([]int, 4)
+		for i, v := range []string{keys, maxKeys, bytes, maxBytes} {
+			if values[i], err = strconv.Atoi(v); err != nil {
+				break
+			}
+		}
+		if !keysOK || !bytesOK || err != nil {
+			return KeyctlQuota{}, false, fmt.Errorf("malformed line in /proc/key-users: %q", line)
+		}
+
+		return KeyctlQuota{Keys: values[0], MaxKeys: values[1], Bytes: values[2], MaxBytes: values[3]}, true, nil
+	}
+	return KeyctlQuota{}, false, nil
+}
+
+// readSysctlInt reads the integer value of the sysctl file at path.
+func readSysctlInt(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
 }
 
 func (k keyctlProvider) Set(service, user, pass string) error {
-	persistentKeyring, err := k.getPersistentKeyring()
+	return k.SetContext(context.Background(), service, user, pass)
+}
+
+func (k keyctlProvider) SetContext(ctx context.Context, service, user, pass string) error {
+	return keyctlError("set", k.set(ctx, service, user, []byte(pass), nil, time.Time{}))
+}
+
+func (k keyctlProvider) SetBytes(service, user string, data []byte) error {
+	return keyctlError("set", k.set(context.Background(), service, user, data, nil, time.Time{}))
+}
+
+// SetWithTTL stores the secret in a key the kernel expires after ttl, rounded
+// up to full seconds.
+func (k keyctlProvider) SetWithTTL(service, user, pass string, ttl time.Duration) error {
+	return keyctlError("set", k.set(context.Background(), service, user, []byte(pass), nil, time.Now().Add(ttl)))
+}
+
+// SetItem stores the secret of item and its metadata in a separate key.
+func (k keyctlProvider) SetItem(service, user string, item Item) error {
+	return keyctlError("set", k.set(context.Background(), service, user, item.Secret, &item, item.Expires))
+}
+
+// set stores data and updates the metadata, replacing label and attributes
+// by those of item if given. The metadata is only stored if item or expires is
+// given or the secret has metadata already. Existing keys are updated in place, keeping
+// their ID and permissions, so concurrent readers never miss them, unless the
+// data moves between user and big_key keys. The keys expire at expires if it
+// isn't zero. Otherwise, set clears the expiry if item is given and keeps that
+// of an existing secret if not.
+func (k keyctlProvider) set(ctx context.Context, service, user string, data []byte, item *Item, expires time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	keyringID, err := k.getKeyring()
 	if err != nil {
 		return err
 	}
 
-	keyName := fmt.Sprintf("%s:%s", service, user)
+	for {
+		serviceID, err := k.serviceKeyring(keyringID, service, true)
+		if err != nil {
+			return err
+		}
+
+		if err := k.setIn(keyringID, serviceID, service, user, data, item, expires); err != nil {
+			return err
+		}
+
+		// a keyring created concurrently for the same service replaces ours
+		// in keyringID, dropping the secret, so store it again in that case.
+		// If the keyring is gone, the service was deleted concurrently.
+		currentID, err := k.serviceKeyring(keyringID, service, false)
+		if errors.Is(err, unix.ENOKEY) || err == nil && currentID == serviceID {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// setIn stores the secret in the keyring of the service with the ID
+// serviceID, see set. Entries stored directly in keyringID by older versions
+// are migrated.
+func (k keyctlProvider) setIn(keyringID, serviceID int, service, user string, data []byte, item *Item, expires time.Time) error {
+	keyName := encodeKeyName(service, user)
+
+	keyID, prevKeyID, err := k.store(serviceID, keyName, data)
+	if err != nil {
+		return err
+	}
+
+	old, metaKeyID := k.readMeta(serviceID, keyName)
+	hasMeta := metaKeyID != 0
+
+	// migrate an entry stored under either name directly in keyringID,
+	// keeping its metadata. Searches descend into the keyring of the service,
+	// but check keyringID itself first, and only keys linked there directly
+	// can be unlinked from it.
+	migrated := false
+	for _, flatName := range []string{keyName, legacyKeyName(service, user)} {
+		if flatKeyID, err := k.search(keyringID, flatName); err == nil {
+			if _, err := unix.KeyctlInt(unix.KEYCTL_UNLINK, flatKeyID, keyringID, 0, 0); err == nil {
+				migrated = true
+			}
+		}
+		if flatOld, flatMetaKeyID := k.readMeta(keyringID, flatName); flatMetaKeyID != 0 {
+			if _, err := unix.KeyctlInt(unix.KEYCTL_UNLINK, flatMetaKeyID, keyringID, 0, 0); err == nil && metaKeyID == 0 {
+				old, hasMeta = flatOld, true
+			}
+		}
+	}
+
+	// updating a user key clears its timeout, so it's set even if it's kept
+	if (prevKeyID != 0 || migrated) && item == nil && expires.IsZero() {
+		expires = old.Expires
+	}
+	if err := k.setTimeout(keyID, expires); err != nil {
+		return err
+	}
+
+	// each key counts against the user's key quota, so plain secrets go
+	// without metadata, and GetItem reports zero timestamps for them
+	if !hasMeta && item == nil && expires.IsZero() {
+		return nil
+	}
+
+	metaData, err := encodeMeta(updateMeta(old, item, time.Now(), expires))
+	if err != nil {
+		return err
+	}
+
+	metaKeyID, _, err = k.store(serviceID, metaKeyPrefix+keyName, metaData)
+	if err != nil {
+		return err
+	}
+
+	return k.setTimeout(metaKeyID, expires)
+}
+
+const (
+	// keyctlUserMaxSize is the largest payload of a user key.
+	keyctlUserMaxSize = 32767
+	// keyctlBigKeyMaxSize is the largest payload add_key accepts, which
+	// limits big_key keys.
+	keyctlBigKeyMaxSize = 1<<20 - 1
+)
+
+// keyctlKeyTypes are the types of the keys holding secrets and metadata:
+// user keys, and big_key keys, which the kernel keeps encrypted in shmem, for
+// payloads too large for user keys.
+var keyctlKeyTypes = []string{"user", "big_key"}
+
+// keyctlKeyType returns the type of the key holding a payload of the given
+// size.
+func keyctlKeyType(size int) string {
+	if size > keyctlUserMaxSize {
+		return "big_key"
+	}
+	return "user"
+}
+
+// isKeyctlKeyType reports whether keys of type typ hold secrets or metadata.
+func isKeyctlKeyType(typ string) bool {
+	return typ == "user" || typ == "big_key"
+}
+
+// search searches the key with the given description among the types of
+// keyctlKeyTypes.
+func (k keyctlProvider) search(keyringID int, desc string) (keyID int, err error) {
+	for _, typ := range keyctlKeyTypes {
+		// the kernel reports unknown types, like big_key if it's disabled,
+		// as ENOKEY as well
+		keyID, err = unix.KeyctlSearch(keyringID, typ, desc, 0)
+		if !errors.Is(err, unix.ENOKEY) {
+			break
+		}
+	}
+	return keyID, err
+}
+
+// store sets the payload of the key with the given description to data,
+// using a big_key key if data is too large for a user key. An existing key
+// of that type in the keyring is updated in place by add_key, which, unlike
+// KEYCTL_UPDATE, isn't limited to a page of data. It keeps the permissions of
+// the key, but the kernel clears its timeout, which the caller has to set
+// again. A key of the other type is unlinked. New keys get the permission mask of k.
+// It returns the ID of the key and of the key it replaces, which is the same
+// if it was updated in place and 0 if there was none.
+func (k keyctlProvider) store(keyringID int, desc string, data []byte) (keyID, prevKeyID int, err error) {
+	if len(data) > keyctlBigKeyMaxSize {
+		return 0, 0, ErrSetDataTooBig
+	}
+
+	prevKeyID, err = k.search(keyringID, desc)
+	if errors.Is(err, unix.ENOKEY) || errors.Is(err, unix.EKEYEXPIRED) || errors.Is(err, unix.EKEYREVOKED) {
+		prevKeyID = 0
+	} else if err != nil {
+		return 0, 0, err
+	}
+
+	typ := keyctlKeyType(len(data))
+	keyID, err = unix.AddKey(typ, desc, data, keyringID)
+	if err != nil {
+		if typ == "big_key" && errors.Is(err, unix.ENODEV) {
+			// the kernel doesn't support big_key keys
+			return 0, 0, ErrSetDataTooBig
+		}
+		return 0, 0, k.quotaError(err)
+	}
+	if keyID == prevKeyID {
+		return keyID, prevKeyID, nil
+	}
+
+	if err := k.restrict(keyringID, keyID); err != nil {
+		return 0, 0, err
+	}
+
+	// the data moved between user and big_key keys
+	if prevKeyID != 0 {
+		_, _ = unix.KeyctlInt(unix.KEYCTL_UNLINK, prevKeyID, keyringID, 0, 0)
+	}
+	return keyID, prevKeyID, nil
+}
+
+// readMeta reads the metadata of the key with the given description. It
+// returns the ID of the key holding the metadata, or 0 if there is none.
+func (k keyctlProvider) readMeta(keyringID int, keyName string) (itemMeta, int) {
+	metaKeyID, err := k.search(keyringID, metaKeyPrefix+keyName)
+	if err != nil {
+		return itemMeta{}, 0
+	}
+	metaData, err := k.read(metaKeyID)
+	if err != nil {
+		return itemMeta{}, metaKeyID
+	}
+	return decodeMeta(metaData), metaKeyID
+}
+
+// find searches the key of service and user in the keyring of the service,
+// falling back to the keys stored directly in the keyring with the ID
+// keyringID by older versions, under the encoded and the legacy name. It
+// returns the ID and description of the key and the ID of the keyring it's
+// linked into.
+func (k keyctlProvider) find(keyringID int, service, user string) (keyID int, keyName string, parentID int, err error) {
+	keyName = encodeKeyName(service, user)
 
-	existingKeyID, err := unix.KeyctlSearch(persistentKeyring, "user", keyName, 0)
+	serviceID, err := k.serviceKeyring(keyringID, service, false)
 	if err == nil {
-		_, _ = unix.KeyctlInt(unix.KEYCTL_UNLINK, existingKeyID, persistentKeyring, 0, 0)
+		keyID, err = k.search(serviceID, keyName)
+		if !errors.Is(err, unix.ENOKEY) {
+			return keyID, keyName, serviceID, err
+		}
+	} else if !errors.Is(err, unix.ENOKEY) {
+		return 0, "", 0, err
+	}
+
+	for _, keyName := range []string{keyName, legacyKeyName(service, user)} {
+		keyID, err = k.search(keyringID, keyName)
+		if !errors.Is(err, unix.ENOKEY) {
+			return keyID, keyName, keyringID, err
+		}
+	}
+	return 0, "", 0, err
+}
+
+// setTimeout makes the kernel expire the key with the given ID at expires,
+// rounded up to full seconds. The kernel's garbage collector then removes the
+// expired key. A zero expires clears the timeout.
+func (k keyctlProvider) setTimeout(keyID int, expires time.Time) error {
+	if expires.IsZero() {
+		_, err := unix.KeyctlInt(unix.KEYCTL_SET_TIMEOUT, keyID, 0, 0, 0)
+		return err
 	}
 
-	_, err = unix.AddKey("user", keyName, []byte(pass), persistentKeyring)
+	timeout := (time.Until(expires) + time.Second - 1) / time.Second
+	if timeout < 1 {
+		// a timeout of 0 would clear the expiry instead
+		timeout = 1
+	}
+
+	_, err := unix.KeyctlInt(unix.KEYCTL_SET_TIMEOUT, keyID, int(timeout), 0, 0)
 	return err
 }
 
 func (k keyctlProvider) Get(service, user string) (string, error) {
-	persistentKeyring, err := k.getPersistentKeyring()
+	return k.GetContext(context.Background(), service, user)
+}
+
+func (k keyctlProvider) GetContext(ctx context.Context, service, user string) (string, error) {
+	data, err := k.get(ctx, service, user)
+	if err != nil {
+		return "", keyctlError("get", err)
+	}
+
+	return string(data), nil
+}
+
+func (k keyctlProvider) GetBytes(service, user string) ([]byte, error) {
+	data, err := k.get(context.Background(), service, user)
+	return data, keyctlError("get", err)
+}
+
+func (k keyctlProvider) get(ctx context.Context, service, user string) ([]byte, error) {
+	data, _, _, err := k.lookup(ctx, service, user)
+	return data, err
+}
+
+// lookup reads the secret of service and user, returning it together with
+// the description of its key and the ID of the keyring it's linked into.
+func (k keyctlProvider) lookup(ctx context.Context, service, user string) ([]byte, string, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", 0, err
+	}
+
+	keyringID, err := k.getKeyring()
 	if err != nil {
-		return "", err
+		return nil, "", 0, err
 	}
 
-	keyName := fmt.Sprintf("%s:%s", service, user)
+	keyID, keyName, parentID, err := k.find(keyringID, service, user)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, "", 0, err
+	}
 
-	keyID, err := unix.KeyctlSearch(persistentKeyring, "user", keyName, 0)
+	data, err := k.read(keyID)
 	if err != nil {
-		return "", ErrNotFound
+		return nil, "", 0, err
 	}
 
+	return data, keyName, parentID, nil
+}
+
+// read reads the payload of the key with the given ID.
+func (k keyctlProvider) read(keyID int) ([]byte, error) {
 	size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, keyID, nil, 0)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	buf := make([]byte, size)
 	_, err = unix.KeyctlBuffer(unix.KEYCTL_READ, keyID, buf, 0)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return string(buf), nil
+	return buf, nil
+}
+
+// GetItem gets a secret and its metadata. Secrets stored without metadata
+// are returned with zero timestamps.
+func (k keyctlProvider) GetItem(service, user string) (Item, error) {
+	item, err := k.getItem(context.Background(), service, user)
+	return item, keyctlError("get", err)
+}
+
+func (k keyctlProvider) getItem(ctx context.Context, service, user string) (Item, error) {
+	data, keyName, parentID, err := k.lookup(ctx, service, user)
+	if err != nil {
+		return Item{}, err
+	}
+
+	metaKeyID, err := k.search(parentID, metaKeyPrefix+keyName)
+	if err != nil {
+		if errors.Is(err, unix.ENOKEY) {
+			return Item{Secret: data}, nil
+		}
+		return Item{}, err
+	}
+
+	metaData, err := k.read(metaKeyID)
+	if err != nil {
+		return Item{}, err
+	}
+
+	return decodeMeta(metaData).item(data), nil
 }
 
 func (k keyctlProvider) Delete(service, user string) error {
-	persistentKeyring, err := k.getPersistentKeyring()
+	return k.DeleteContext(context.Background(), service, user)
+}
+
+func (k keyctlProvider) DeleteContext(ctx context.Context, service, user string) error {
+	return keyctlError("delete", k.remove(ctx, service, user))
+}
+
+func (k keyctlProvider) remove(ctx context.Context, service, user string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	keyringID, err := k.getKeyring()
 	if err != nil {
 		return err
 	}
 
-	keyName := fmt.Sprintf("%s:%s", service, user)
+	// remove the entry from the keyring of the service, and the entry stored
+	// directly in keyringID under both names, in case it wasn't migrated yet
+	type location struct {
+		parentID int
+		keyName  string
+	}
+	locations := []location{
+		{keyringID, encodeKeyName(service, user)},
+		{keyringID, legacyKeyName(service, user)},
+	}
+	serviceID, err := k.serviceKeyring(keyringID, service, false)
+	if err == nil {
+		locations = append([]location{{serviceID, encodeKeyName(service, user)}}, locations...)
+	} else if !errors.Is(err, unix.ENOKEY) {
+		return err
+	}
+
+	found := false
+	for _, loc := range locations {
+		keyID, err := k.search(loc.parentID, loc.keyName)
+		if err != nil {
+			if errors.Is(err, unix.ENOKEY) {
+				continue
+			}
+			return err
+		}
 
-	keyID, err := unix.KeyctlSearch(persistentKeyring, "user", keyName, 0)
-	if err != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if _, err := unix.KeyctlInt(unix.KEYCTL_UNLINK, keyID, loc.parentID, 0, 0); err != nil {
+			return err
+		}
+
+		k.unlinkMeta(loc.parentID, loc.keyName)
+		found = true
+	}
+
+	if !found {
 		return ErrNotFound
 	}
+	return nil
+}
+
+// unlinkMeta removes the metadata of the key with the given description, if
+// there is any.
+func (k keyctlProvider) unlinkMeta(keyringID int, keyName string) {
+	if metaKeyID, err := k.search(keyringID, metaKeyPrefix+keyName); err == nil {
+		_, _ = unix.KeyctlInt(unix.KEYCTL_UNLINK, metaKeyID, keyringID, 0, 0)
+	}
+}
 
-	_, err = unix.KeyctlInt(unix.KEYCTL_UNLINK, keyID, persistentKeyring, 0, 0)
-	return err
+// keyctlKey is a key linked into a keyring.
+type keyctlKey struct {
+	id   int
+	typ  string
+	desc string
+}
+
+// readKeyring returns the keys linked into the keyring with the given ID,
+// reading their IDs with KEYCTL_READ and type and description with
+// KEYCTL_DESCRIBE. Keys which vanish in the meantime or can't be described
+// are skipped.
+func (k keyctlProvider) readKeyring(keyringID int) ([]keyctlKey, error) {
+	var buf []byte
+	for {
+		size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, keyringID, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		// retry if keys were linked since the size was read
+		if size <= len(buf) {
+			buf = buf[:size]
+			break
+		}
+		buf = make([]byte, size)
+	}
+
+	order := binary.ByteOrder(binary.LittleEndian)
+	if cpu.IsBigEndian {
+		order = binary.BigEndian
+	}
+
+	keys := make([]keyctlKey, 0, len(buf)/4)
+	for i := 0; i+4 <= len(buf); i += 4 {
+		info, err := k.describe(int(int32(order.Uint32(buf[i:]))))
+		if err != nil {
+			continue
+		}
+		keys = append(keys, keyctlKey{id: info.ID, typ: info.Type, desc: info.Description})
+	}
+
+	return keys, nil
+}
+
+// describe describes the key with the given ID.
+func (k keyctlProvider) describe(keyID int) (KeyctlKeyInfo, error) {
+	desc, err := unix.KeyctlString(unix.KEYCTL_DESCRIBE, keyID)
+	if err != nil {
+		return KeyctlKeyInfo{}, err
+	}
+
+	// the description has the form "type;uid;gid;perm;description"
+	fields := strings.SplitN(desc, ";", 5)
+	if len(fields) != 5 {
+		return KeyctlKeyInfo{}, fmt.Errorf("malformed description of key %d: %q", keyID, desc)
+	}
+	uid, uidErr := strconv.Atoi(fields[1])
+	gid, gidErr := strconv.Atoi(fields[2])
+	perm, permErr := strconv.ParseUint(fields[3], 16, 32)
+	if uidErr != nil || gidErr != nil || permErr != nil {
+		return KeyctlKeyInfo{}, fmt.Errorf("malformed description of key %d: %q", keyID, desc)
+	}
+
+	return KeyctlKeyInfo{
+		ID:          keyID,
+		Type:        fields[0],
+		UID:         uid,
+		GID:         gid,
+		Perm:        KeyctlPerm(perm),
+		Description: fields[4],
+	}, nil
+}
+
+// InspectKeyring describes the keyring holding the keyrings of the services.
+func (k keyctlProvider) InspectKeyring() (KeyctlKeyInfo, error) {
+	keyringID, err := k.getKeyring()
+	if err != nil {
+		return KeyctlKeyInfo{}, keyctlError("inspect", err)
+	}
+
+	info, err := k.describe(keyringID)
+	return info, keyctlError("inspect", err)
+}
+
+// InspectService describes the keyring holding the secrets of service.
+func (k keyctlProvider) InspectService(service string) (KeyctlKeyInfo, error) {
+	keyringID, err := k.getKeyring()
+	if err != nil {
+		return KeyctlKeyInfo{}, keyctlError("inspect", err)
+	}
+
+	serviceID, err := k.serviceKeyring(keyringID, service, false)
+	if err != nil {
+		return KeyctlKeyInfo{}, keyctlError("inspect", err)
+	}
+
+	info, err := k.describe(serviceID)
+	return info, keyctlError("inspect", err)
+}
+
+// Inspect describes the key holding the secret of service and user, followed
+// by the key holding its metadata if there is one.
+func (k keyctlProvider) Inspect(service, user string) ([]KeyctlKeyInfo, error) {
+	infos, err := k.inspect(service, user)
+	return infos, keyctlError("inspect", err)
+}
+
+func (k keyctlProvider) inspect(service, user string) ([]KeyctlKeyInfo, error) {
+	keyringID, err := k.getKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, keyName, parentID, err := k.find(keyringID, service, user)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := k.describe(keyID)
+	if err != nil {
+		return nil, err
+	}
+	infos := []KeyctlKeyInfo{info}
+
+	if metaKeyID, err := k.search(parentID, metaKeyPrefix+keyName); err == nil {
+		info, err := k.describe(metaKeyID)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// serviceKey is a key holding a secret of a service.
+type serviceKey struct {
+	keyctlKey
+	// user is the user the secret belongs to.
+	user string
+	// metaID is the ID of the key holding the metadata, or 0 if there's none.
+	metaID int
+}
+
+// serviceKeys returns all keys stored for a given service. Keys with the
+// legacy, ambiguous names are matched by prefix, as they were before.
+func (k keyctlProvider) serviceKeys(keyringID int, service string) ([]serviceKey, error) {
+	linked, err := k.readKeyring(keyringID)
+	if err != nil {
+		return nil, err
+	}
+
+	metaIDs := make(map[string]int)
+	for _, key := range linked {
+		if isKeyctlKeyType(key.typ) && strings.HasPrefix(key.desc, metaKeyPrefix) {
+			metaIDs[strings.TrimPrefix(key.desc, metaKeyPrefix)] = key.id
+		}
+	}
+
+	var keys []serviceKey
+	for _, key := range linked {
+		if !isKeyctlKeyType(key.typ) || strings.HasPrefix(key.desc, metaKeyPrefix) {
+			continue
+		}
+
+		user, ok := "", false
+		if keyService, keyUser, encoded := decodeKeyName(key.desc); encoded {
+			user, ok = keyUser, keyService == service
+		} else {
+			user, ok = legacyServiceUser(key.desc, service)
+		}
+
+		if ok {
+			keys = append(keys, serviceKey{keyctlKey: key, user: user, metaID: metaIDs[key.desc]})
+		}
+	}
+
+	return keys, nil
 }
 
 // DeleteAll deletes all secrets for a given service.
 func (k keyctlProvider) DeleteAll(service string) error {
+	return k.DeleteAllContext(context.Background(), service)
+}
+
+// DeleteAllContext deletes all secrets for a given service.
+func (k keyctlProvider) DeleteAllContext(ctx context.Context, service string) error {
+	return keyctlError("delete all", k.removeAll(ctx, service))
+}
+
+func (k keyctlProvider) removeAll(ctx context.Context, service string) error {
 	if service == "" {
 		return ErrNotFound
 	}
 
-	persistentKeyring, err := k.getPersistentKeyring()
+	keyringID, err := k.getKeyring()
 	if err != nil {
 		return err
 	}
 
-	cmd := exec.Command("keyctl", "show", fmt.Sprintf("%d", persistentKeyring))
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil
+	// the keys go away with the keyring of the service, unless they are
+	// linked elsewhere as well
+	serviceID, err := k.serviceKeyring(keyringID, service, false)
+	if err == nil {
+		if _, err := unix.KeyctlInt(unix.KEYCTL_UNLINK, serviceID, keyringID, 0, 0); err != nil && !errors.Is(err, unix.ENOENT) && !errors.Is(err, unix.ENOKEY) {
+			return err
+		}
+	} else if !errors.Is(err, unix.ENOKEY) {
+		return err
 	}
 
-	lines := strings.Split(string(output), "\n")
-	prefix := fmt.Sprintf("%s:", service)
+	// remove the keys stored directly in keyringID by older versions
+	keys, err := k.serviceKeys(keyringID, service)
+	if err != nil {
+		return fmt.Errorf("failed to enumerate keyring: %w", err)
+	}
 
-	for _, line := range lines {
-		if !strings.Contains(line, prefix) {
-			continue
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
-		parts := strings.Split(line, "user:")
-		if len(parts) < 2 {
-			continue
+		// the key might have been removed concurrently
+		if _, err := unix.KeyctlInt(unix.KEYCTL_UNLINK, key.id, keyringID, 0, 0); err != nil && !errors.Is(err, unix.ENOENT) && !errors.Is(err, unix.ENOKEY) {
+			return err
+		}
+		if key.metaID != 0 {
+			_, _ = unix.KeyctlInt(unix.KEYCTL_UNLINK, key.metaID, keyringID, 0, 0)
 		}
+	}
 
-		keyDesc := strings.TrimSpace(parts[1])
-		if !strings.HasPrefix(keyDesc, service+":") {
-			continue
+	return nil
+}
+
+// List returns the users which have a secret stored for a given service.
+func (k keyctlProvider) List(service string) ([]string, error) {
+	return k.ListContext(context.Background(), service)
+}
+
+// ListContext returns the users which have a secret stored for a given
+// service.
+func (k keyctlProvider) ListContext(ctx context.Context, service string) ([]string, error) {
+	users, err := k.list(ctx, service)
+	return users, keyctlError("list", err)
+}
+
+func (k keyctlProvider) list(ctx context.Context, service string) ([]string, error) {
+	keyringID, err := k.getKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var keys []serviceKey
+	serviceID, err := k.serviceKeyring(keyringID, service, false)
+	if err == nil {
+		if keys, err = k.serviceKeys(serviceID, service); err != nil {
+			return nil, fmt.Errorf("failed to enumerate keyring: %w", err)
 		}
+	} else if !errors.Is(err, unix.ENOKEY) {
+		return nil, err
+	}
 
-		keyID, err := unix.KeyctlSearch(persistentKeyring, "user", keyDesc, 0)
-		if err == nil {
-			_, _ = unix.KeyctlInt(unix.KEYCTL_UNLINK, keyID, persistentKeyring, 0, 0)
+	// keys stored directly in keyringID by older versions
+	flatKeys, err := k.serviceKeys(keyringID, service)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate keyring: %w", err)
+	}
+	keys = append(keys, flatKeys...)
+
+	// a secret might be stored under several names until it's migrated
+	seen := make(map[string]bool, len(keys))
+	users := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !seen[key.user] {
+			seen[key.user] = true
+			users = append(users, key.user)
 		}
 	}
+	sort.Strings(users)
 
-	return nil
+	return users, nil
 }
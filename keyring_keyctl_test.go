@@ -3,11 +3,85 @@
 package keyring
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	osuser "os/user"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
 )
 
+// TestMain runs the tests in an anonymous session keyring, like
+// "keyctl session -", so that they leave the developer's session keyring
+// alone. Joining a session keyring only affects the calling thread, so the
+// test binary is run again from a thread which joined one, and all threads of
+// the new process inherit it.
+func TestMain(m *testing.M) {
+	if os.Getenv("GO_KEYRING_TEST_SESSION") != "" {
+		os.Exit(m.Run())
+	}
+
+	runtime.LockOSThread()
+	if _, err := unix.KeyctlInt(unix.KEYCTL_JOIN_SESSION_KEYRING, 0, 0, 0, 0); err != nil {
+		// the keyctl tests report the missing kernel support themselves
+		os.Exit(m.Run())
+	}
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), "GO_KEYRING_TEST_SESSION=1")
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		fmt.Fprintf(os.Stderr, "Failed to run tests in a new session keyring: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// newTestKeyctlProvider returns a provider rooted in a private keyring of the
+// test, which is linked into the session keyring and removed once the test is
+// done. This keeps tests from seeing each other's keys, so they can run in
+// parallel, and away from the user's persistent keyring.
+func newTestKeyctlProvider(t *testing.T) keyctlProvider {
+	t.Helper()
+
+	ringID, err := unix.AddKey("keyring", "go-keyring-test:"+t.Name(), nil, unix.KEY_SPEC_SESSION_KEYRING)
+	if err != nil {
+		t.Fatalf("Failed to create keyring: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = unix.KeyctlInt(unix.KEYCTL_UNLINK, ringID, unix.KEY_SPEC_SESSION_KEYRING, 0, 0)
+	})
+
+	provider, err := newKeyctlProvider(KeyctlKeyringID(ringID), "", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+// sharedKeyringTests reports whether tests may write to keyrings shared with
+// the rest of the system, e.g. the user keyring, which they leave alone by
+// default so that they can't clobber the user's secrets.
+func sharedKeyringTests() bool {
+	return os.Getenv("GO_KEYRING_TEST_SHARED_KEYRINGS") != ""
+}
+
 func TestKeyctlProvider(t *testing.T) {
-	provider := keyctlProvider{}
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
 
 	service := "test-keyctl-service"
 	user := "test-keyctl-user"
@@ -51,7 +125,8 @@ func TestKeyctlProvider(t *testing.T) {
 }
 
 func TestKeyctlProviderMultiLine(t *testing.T) {
-	provider := keyctlProvider{}
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
 
 	service := "test-keyctl-multiline"
 	user := "test-user"
@@ -79,7 +154,8 @@ line3`
 }
 
 func TestKeyctlProviderSpecialChars(t *testing.T) {
-	provider := keyctlProvider{}
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
 
 	service := "test-keyctl-special"
 	user := "test-user"
@@ -105,7 +181,8 @@ func TestKeyctlProviderSpecialChars(t *testing.T) {
 }
 
 func TestKeyctlProviderDeleteAll(t *testing.T) {
-	provider := keyctlProvider{}
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
 
 	service := "test-keyctl-deleteall"
 
@@ -140,7 +217,8 @@ func TestKeyctlProviderDeleteAll(t *testing.T) {
 }
 
 func TestKeyctlProviderDeleteAllEmpty(t *testing.T) {
-	provider := keyctlProvider{}
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
 
 	err := provider.DeleteAll("")
 	if err != ErrNotFound {
@@ -149,7 +227,8 @@ func TestKeyctlProviderDeleteAllEmpty(t *testing.T) {
 }
 
 func TestKeyctlProviderUpdate(t *testing.T) {
-	provider := keyctlProvider{}
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
 
 	service := "test-keyctl-update"
 	user := "test-user"
@@ -181,7 +260,8 @@ func TestKeyctlProviderUpdate(t *testing.T) {
 }
 
 func TestKeyctlProviderMultipleServices(t *testing.T) {
-	provider := keyctlProvider{}
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
 
 	service1 := "test-keyctl-service1"
 	service2 := "test-keyctl-service2"
@@ -243,7 +323,8 @@ func TestKeyctlProviderMultipleServices(t *testing.T) {
 }
 
 func TestKeyctlProviderEmptyPassword(t *testing.T) {
-	provider := keyctlProvider{}
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
 
 	service := "test-keyctl-empty"
 	user := "test-user"
@@ -259,7 +340,8 @@ func TestKeyctlProviderEmptyPassword(t *testing.T) {
 }
 
 func TestKeyctlProviderBinaryData(t *testing.T) {
-	provider := keyctlProvider{}
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
 
 	service := "test-keyctl-binary"
 	user := "test-user"
@@ -283,3 +365,856 @@ func TestKeyctlProviderBinaryData(t *testing.T) {
 
 	_ = provider.Delete(service, user)
 }
+
+func TestKeyctlProviderList(t *testing.T) {
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
+
+	service := "test-keyctl-list"
+
+	_ = provider.DeleteAll(service)
+
+	users := []string{"user1", "user2", "user3"}
+	for _, user := range users {
+		err := provider.Set(service, user, "password-"+user)
+		if err != nil {
+			t.Fatalf("Failed to set password for %s: %v", user, err)
+		}
+	}
+
+	listed, err := provider.List(service)
+	if err != nil {
+		t.Fatalf("Failed to list users: %v", err)
+	}
+
+	if len(listed) != len(users) {
+		t.Fatalf("Expected users %v, got %v", users, listed)
+	}
+	for i, user := range users {
+		if listed[i] != user {
+			t.Errorf("Expected user %q at index %d, got %q", user, i, listed[i])
+		}
+	}
+
+	_ = provider.DeleteAll(service)
+}
+
+func TestKeyctlProviderContextCanceled(t *testing.T) {
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
+
+	service := "test-keyctl-context"
+	user := "test-user"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := provider.SetContext(ctx, service, user, "password")
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	_, err = provider.Get(service, user)
+	if err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound after canceled set, got %v", err)
+	}
+
+	_, err = provider.GetContext(ctx, service, user)
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestKeyctlProviderBytes(t *testing.T) {
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
+
+	service := "test-keyctl-bytes"
+	user := "test-user"
+	data := []byte{0x00, 0x30, 0x82, 0xFF, 0x0A, 0x00}
+
+	_ = provider.Delete(service, user)
+
+	err := provider.SetBytes(service, user, data)
+	if err != nil {
+		t.Fatalf("Failed to set bytes: %v", err)
+	}
+
+	retrieved, err := provider.GetBytes(service, user)
+	if err != nil {
+		t.Fatalf("Failed to get bytes: %v", err)
+	}
+
+	if !bytes.Equal(retrieved, data) {
+		t.Errorf("Expected data %v, got %v", data, retrieved)
+	}
+
+	_ = provider.Delete(service, user)
+}
+
+func TestKeyctlError(t *testing.T) {
+	t.Parallel()
+	err := keyctlError("get", unix.EACCES)
+	if !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("Expected ErrAccessDenied, got %v", err)
+	}
+	if !errors.Is(err, unix.EACCES) {
+		t.Errorf("Expected error to wrap EACCES, got %v", err)
+	}
+
+	for _, errno := range []error{unix.ENOKEY, unix.EKEYEXPIRED, unix.EKEYREVOKED} {
+		if err := keyctlError("get", errno); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound for %v, got %v", errno, err)
+		}
+	}
+
+	if err := keyctlError("set", unix.EDQUOT); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected ErrQuotaExceeded, got %v", err)
+	}
+}
+
+func TestKeyctlProviderItem(t *testing.T) {
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
+
+	service := "test-keyctl-item"
+	user := "test-user"
+
+	_ = provider.Delete(service, user)
+	defer provider.Delete(service, user)
+
+	err := provider.SetItem(service, user, Item{
+		Label:      "label",
+		Attributes: map[string]string{"host": "example.com"},
+		Secret:     []byte("secret"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to set item: %v", err)
+	}
+
+	first, err := provider.GetItem(service, user)
+	if err != nil {
+		t.Fatalf("Failed to get item: %v", err)
+	}
+	if first.Label != "label" || first.Attributes["host"] != "example.com" || string(first.Secret) != "secret" {
+		t.Errorf("Unexpected item %+v", first)
+	}
+
+	// a plain Set keeps the metadata but updates the modification time
+	if err := provider.Set(service, user, "changed"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	second, err := provider.GetItem(service, user)
+	if err != nil {
+		t.Fatalf("Failed to get item: %v", err)
+	}
+	if second.Label != "label" || string(second.Secret) != "changed" {
+		t.Errorf("Unexpected item %+v", second)
+	}
+	if !second.Created.Equal(first.Created) || second.Modified.Before(first.Modified) {
+		t.Errorf("Expected created %s and modified after %s, got %s and %s", first.Created, first.Modified, second.Created, second.Modified)
+	}
+}
+
+func TestKeyctlProviderSetWithTTL(t *testing.T) {
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
+
+	service := "test-keyctl-ttl"
+	user := "test-user"
+
+	_ = provider.Delete(service, user)
+	defer provider.Delete(service, user)
+
+	if err := provider.SetWithTTL(service, user, "secret", time.Second); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	if _, err := provider.Get(service, user); err != nil {
+		t.Fatalf("Failed to get password: %v", err)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+
+	_, err := provider.Get(service, user)
+	if err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound after expiry, got %v", err)
+	}
+}
+
+func TestKeyctlProviderLegacyName(t *testing.T) {
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
+
+	service := "test-keyctl-legacy"
+	user := "test-user"
+
+	ring, err := provider.getKeyring()
+	if err != nil {
+		t.Fatalf("Failed to get keyring: %v", err)
+	}
+
+	_ = provider.Delete(service, user)
+	defer provider.Delete(service, user)
+
+	// entries written by older versions are still read
+	if _, err := unix.AddKey("user", legacyKeyName(service, user), []byte("legacy"), ring); err != nil {
+		t.Fatalf("Failed to add legacy key: %v", err)
+	}
+
+	pw, err := provider.Get(service, user)
+	if err != nil || pw != "legacy" {
+		t.Fatalf("Expected legacy password, got %q and %v", pw, err)
+	}
+
+	// and migrated on write
+	if err := provider.Set(service, user, "migrated"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	if _, err := unix.KeyctlSearch(ring, "user", legacyKeyName(service, user), 0); err == nil {
+		t.Errorf("Expected legacy key to be removed")
+	}
+
+	pw, err = provider.Get(service, user)
+	if err != nil || pw != "migrated" {
+		t.Errorf("Expected migrated password, got %q and %v", pw, err)
+	}
+}
+
+func TestKeyctlProviderNoCollision(t *testing.T) {
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
+
+	_ = provider.Delete("test-keyctl-a:b", "c")
+	_ = provider.Delete("test-keyctl-a", "b:c")
+	defer provider.Delete("test-keyctl-a:b", "c")
+	defer provider.Delete("test-keyctl-a", "b:c")
+
+	if err := provider.Set("test-keyctl-a:b", "c", "first"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if err := provider.Set("test-keyctl-a", "b:c", "second"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	pw, err := provider.Get("test-keyctl-a:b", "c")
+	if err != nil || pw != "first" {
+		t.Errorf("Expected first password, got %q and %v", pw, err)
+	}
+}
+
+func TestKeyctlProviderListDescriptions(t *testing.T) {
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
+
+	// descriptions which confused parsing the output of keyctl show
+	service := "test keyctl user: list"
+	users := []string{"user: one", "two;three", "four five"}
+
+	_ = provider.DeleteAll(service)
+	defer provider.DeleteAll(service)
+
+	for _, user := range users {
+		if err := provider.SetItem(service, user, Item{Label: user, Secret: []byte(password)}); err != nil {
+			t.Fatalf("Failed to set password for %q: %v", user, err)
+		}
+	}
+
+	listed, err := provider.List(service)
+	if err != nil {
+		t.Fatalf("Failed to list users: %v", err)
+	}
+	if len(listed) != 3 || listed[0] != "four five" || listed[1] != "two;three" || listed[2] != "user: one" {
+		t.Errorf("Expected users %q, got %q", users, listed)
+	}
+
+	if err := provider.DeleteAll(service); err != nil {
+		t.Fatalf("Failed to delete all: %v", err)
+	}
+
+	listed, err = provider.List(service)
+	if err != nil {
+		t.Fatalf("Failed to list users: %v", err)
+	}
+	if len(listed) != 0 {
+		t.Errorf("Expected no users, got %q", listed)
+	}
+
+	// the metadata is removed as well
+	keyringID, err := provider.getKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range users {
+		if _, err := unix.KeyctlSearch(keyringID, "user", metaKeyPrefix+encodeKeyName(service, user), 0); err == nil {
+			t.Errorf("Expected metadata of %q to be removed", user)
+		}
+	}
+}
+
+func TestKeyctlProviderKeyrings(t *testing.T) {
+	t.Parallel()
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	persistent, err := keyctlProvider{}.getKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the keyrings of the anonymous session joined by TestMain are private
+	// to the test, the user keyrings are shared with the user's other
+	// processes
+	type keyringCase struct {
+		keyring KeyctlKeyring
+		name    string
+	}
+	cases := []keyringCase{
+		{KeyctlSession, ""},
+		{KeyctlProcess, ""},
+		{KeyctlThread, ""},
+		{KeyctlProcess, "test-keyctl-named"},
+	}
+	if sharedKeyringTests() {
+		cases = append(cases, keyringCase{KeyctlUser, ""}, keyringCase{KeyctlUserSession, ""})
+	}
+
+	service := "go-keyring-test:" + t.Name()
+
+	for _, tc := range cases {
+		provider, err := newKeyctlProvider(tc.keyring, tc.name, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := provider.Set(service, user, password); err != nil {
+			t.Fatalf("Failed to set password in %s keyring: %v", tc.keyring, err)
+		}
+
+		pw, err := provider.Get(service, user)
+		if err != nil || pw != password {
+			t.Errorf("Expected password %s in %s keyring, got %q and %v", password, tc.keyring, pw, err)
+		}
+
+		users, err := provider.List(service)
+		if err != nil || len(users) != 1 || users[0] != user {
+			t.Errorf("Expected users [%s] in %s keyring, got %v and %v", user, tc.keyring, users, err)
+		}
+
+		keyringID, err := provider.getKeyring()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.name != "" {
+			// the named keyring is linked into the process keyring
+			processID, err := unix.KeyctlGetKeyringID(unix.KEY_SPEC_PROCESS_KEYRING, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if namedID, err := unix.KeyctlSearch(processID, "keyring", tc.name, 0); err != nil || namedID != keyringID {
+				t.Errorf("Expected keyring %q in the process keyring, got %d and %v", tc.name, namedID, err)
+			}
+		}
+		if keyringID != persistent {
+			if _, err := unix.KeyctlSearch(persistent, "user", encodeKeyName(service, user), 0); err == nil {
+				t.Errorf("Expected no secret in the persistent keyring for %s keyring", tc.keyring)
+			}
+		}
+
+		if err := provider.Delete(service, user); err != nil {
+			t.Errorf("Failed to delete password in %s keyring: %v", tc.keyring, err)
+		}
+	}
+
+	if _, err := Open("keyctl", Config{Options: map[string]string{"keyring": "unknown"}}); err == nil {
+		t.Errorf("Expected error for unknown keyring")
+	}
+}
+
+func TestKeyctlProviderUpdateInPlace(t *testing.T) {
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
+	service := "test-keyctl-update-in-place"
+	defer provider.DeleteAll(service)
+
+	if err := provider.SetWithTTL(service, user, password, time.Hour); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	keyringID, err := provider.getKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID, err := unix.KeyctlSearch(keyringID, "user", encodeKeyName(service, user), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// restrict the key's permissions, which Set must keep
+	const perm = 0x3f0b0000
+	if err := unix.KeyctlSetperm(keyID, perm); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if _, err := provider.Get(service, user); err != nil {
+					t.Errorf("Failed to get password during update: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	// alternate with values larger than a page, which KEYCTL_UPDATE rejects
+	large := strings.Repeat("x", 10000)
+	for i := 0; i < 200; i++ {
+		value := fmt.Sprintf("password-%d", i)
+		if i%2 == 0 {
+			value = large + value
+		}
+		if err := provider.Set(service, user, value); err != nil {
+			t.Errorf("Failed to set password: %v", err)
+			break
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	newKeyID, err := unix.KeyctlSearch(keyringID, "user", encodeKeyName(service, user), 0)
+	if err != nil || newKeyID != keyID {
+		t.Errorf("Expected key %d to be updated in place, got %d and %v", keyID, newKeyID, err)
+	}
+
+	desc, err := unix.KeyctlString(unix.KEYCTL_DESCRIBE, keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields := strings.SplitN(desc, ";", 5); len(fields) != 5 || fields[3] != fmt.Sprintf("%x", perm) {
+		t.Errorf("Expected permissions %x to be kept, got %q", perm, desc)
+	}
+
+	item, err := provider.GetItem(service, user)
+	if err != nil {
+		t.Fatalf("Failed to get item: %v", err)
+	}
+	if string(item.Secret) != "password-199" {
+		t.Errorf("Expected last password, got %q", item.Secret)
+	}
+	if item.Expires.IsZero() {
+		t.Errorf("Expected expiry to be kept")
+	}
+
+	// the kernel clears the timeout of updated keys, so it's set again
+	if err := provider.SetWithTTL(service, user, password, time.Second); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if err := provider.Set(service, user, password+"2"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	time.Sleep(2500 * time.Millisecond)
+
+	_, err = provider.Get(service, user)
+	assertError(t, err, ErrNotFound)
+}
+
+func TestKeyctlProviderSingleKey(t *testing.T) {
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
+	service := "test-keyctl-single-key"
+
+	// a plain secret takes a single key of the user's quota
+	if err := provider.Set(service, user, password); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	infos, err := provider.Inspect(service, user)
+	if err != nil || len(infos) != 1 || infos[0].Description != encodeKeyName(service, user) {
+		t.Errorf("Expected only the key of the secret, got %+v and %v", infos, err)
+	}
+
+	item, err := provider.GetItem(service, user)
+	if err != nil || string(item.Secret) != password || !item.Created.IsZero() {
+		t.Errorf("Expected password %s without metadata, got %+v and %v", password, item, err)
+	}
+
+	// an expiry needs metadata, which later writes keep updating
+	if err := provider.SetWithTTL(service, user, password, time.Hour); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if err := provider.Set(service, user, password+"2"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	infos, err = provider.Inspect(service, user)
+	if err != nil || len(infos) != 2 {
+		t.Errorf("Expected the secret and metadata keys, got %+v and %v", infos, err)
+	}
+	item, err = provider.GetItem(service, user)
+	if err != nil || item.Expires.IsZero() || item.Modified.IsZero() {
+		t.Errorf("Expected expiry and modification time to be kept, got %+v and %v", item, err)
+	}
+}
+
+func TestKeyctlProviderPermissions(t *testing.T) {
+	const service = "test-keyctl-permissions"
+
+	t.Parallel()
+	defaultProvider := newTestKeyctlProvider(t)
+
+	// with a label, so that the metadata key is created as well
+	item := Item{Label: "label", Secret: []byte(password)}
+	if err := defaultProvider.SetItem(service, user, item); err != nil {
+		t.Fatalf("Failed to set item: %v", err)
+	}
+
+	infos, err := defaultProvider.Inspect(service, user)
+	if err != nil {
+		t.Fatalf("Failed to inspect keys: %v", err)
+	}
+	if len(infos) != 2 || infos[0].Description != encodeKeyName(service, user) || infos[1].Description != metaKeyPrefix+encodeKeyName(service, user) {
+		t.Fatalf("Expected the secret and metadata keys, got %+v", infos)
+	}
+	for _, info := range infos {
+		if info.Type != "user" || info.UID != os.Getuid() || info.Perm != DefaultKeyctlPerm {
+			t.Errorf("Expected user key of uid %d with permissions %s, got %+v", os.Getuid(), DefaultKeyctlPerm, info)
+		}
+		if info.Perm.Possessor()&KeyctlPermLink != 0 || info.Perm.User() != 0 {
+			t.Errorf("Expected no link permission and nothing for the user, got %s", info.Perm)
+		}
+	}
+
+	ring, err := defaultProvider.getKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	perm := KeyctlPermMask(keyctlPermRequired, KeyctlPermView, 0, 0)
+	kr, err := Open("keyctl", Config{Options: map[string]string{
+		"keyring":      string(KeyctlKeyringID(ring)),
+		"keyring-name": "test-keyctl-perm",
+		"perm":         "0x" + perm.String(),
+	}})
+	if err != nil {
+		t.Fatalf("Failed to open keyring: %v", err)
+	}
+	provider := kr.(KeyctlInspector)
+
+	if err := kr.(ItemKeyring).SetItem(service, user, item); err != nil {
+		t.Fatalf("Failed to set item: %v", err)
+	}
+
+	info, err := provider.InspectKeyring()
+	if err != nil {
+		t.Fatalf("Failed to inspect keyring: %v", err)
+	}
+	if info.Type != "keyring" || info.Description != "test-keyctl-perm" || info.Perm != perm {
+		t.Errorf("Expected keyring with permissions %s, got %+v", perm, info)
+	}
+
+	infos, err = provider.Inspect(service, user)
+	if err != nil || len(infos) != 2 || infos[0].Perm != perm || infos[1].Perm != perm {
+		t.Errorf("Expected keys with permissions %s, got %+v and %v", perm, infos, err)
+	}
+
+	if _, err := provider.Inspect(service, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	for _, perm := range []string{"0x3f", "none"} {
+		if _, err := Open("keyctl", Config{Options: map[string]string{"perm": perm}}); err == nil {
+			t.Errorf("Expected error for permission mask %q", perm)
+		}
+	}
+}
+
+func TestKeyctlProviderBigKey(t *testing.T) {
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
+	const service = "test-keyctl-big-key"
+	defer provider.DeleteAll(service)
+
+	// the kernel reports big_key as unknown type if it's disabled
+	probeID, err := unix.AddKey("big_key", "test-keyctl-big-key-probe", make([]byte, keyctlUserMaxSize+1), unix.KEY_SPEC_PROCESS_KEYRING)
+	bigKeys := err == nil
+	if bigKeys {
+		_, _ = unix.KeyctlInt(unix.KEYCTL_UNLINK, probeID, unix.KEY_SPEC_PROCESS_KEYRING, 0, 0)
+	}
+
+	if err := provider.Set(service, user, strings.Repeat("x", keyctlUserMaxSize)); err != nil {
+		t.Fatalf("Failed to set password of the user key limit: %v", err)
+	}
+
+	large := strings.Repeat("x", 100000)
+	err = provider.Set(service, user, large)
+	if !bigKeys {
+		if !errors.Is(err, ErrSetDataTooBig) {
+			t.Errorf("Expected ErrSetDataTooBig without big_key support, got %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Failed to set large password: %v", err)
+	}
+
+	if pw, err := provider.Get(service, user); err != nil || pw != large {
+		t.Errorf("Expected large password, got %d bytes and %v", len(pw), err)
+	}
+	infos, err := provider.Inspect(service, user)
+	if err != nil || infos[0].Type != "big_key" {
+		t.Errorf("Expected big_key key, got %+v and %v", infos, err)
+	}
+
+	// moving back to a user key leaves no big_key key behind
+	if err := provider.Set(service, user, password); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	keyringID, err := provider.getKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unix.KeyctlSearch(keyringID, "big_key", encodeKeyName(service, user), 0); err == nil {
+		t.Errorf("Expected big_key key to be removed")
+	}
+	if users, err := provider.List(service); err != nil || len(users) != 1 {
+		t.Errorf("Expected a single user, got %v and %v", users, err)
+	}
+}
+
+func TestKeyctlProviderTooBig(t *testing.T) {
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
+	const service = "test-keyctl-too-big"
+	defer provider.DeleteAll(service)
+
+	if err := provider.Set(service, user, strings.Repeat("x", keyctlBigKeyMaxSize+1)); !errors.Is(err, ErrSetDataTooBig) {
+		t.Errorf("Expected ErrSetDataTooBig, got %v", err)
+	}
+	if _, err := provider.Get(service, user); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestKeyctlProviderServiceKeyrings(t *testing.T) {
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
+	const service = "test-keyctl-service-keyring"
+	defer provider.DeleteAll(service)
+
+	ring, err := provider.getKeyring()
+	if err != nil {
+		t.Fatalf("Failed to get keyring: %v", err)
+	}
+
+	// linkedIn reports whether a key with the given description is linked
+	// directly into the keyring with the given ID
+	linkedIn := func(keyringID int, desc string) bool {
+		keys, err := provider.readKeyring(keyringID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range keys {
+			if key.desc == desc {
+				return true
+			}
+		}
+		return false
+	}
+
+	// an entry stored directly in the keyring by an older version
+	flatName := encodeKeyName(service, "flat")
+	if _, err := unix.AddKey("user", flatName, []byte("flat"), ring); err != nil {
+		t.Fatalf("Failed to add flat key: %v", err)
+	}
+
+	if err := provider.Set(service, user, password); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	info, err := provider.InspectService(service)
+	if err != nil {
+		t.Fatalf("Failed to inspect keyring of service: %v", err)
+	}
+	if info.Type != "keyring" || info.Description != serviceKeyringName(service) || info.Perm != DefaultKeyctlPerm {
+		t.Errorf("Expected keyring of service with permissions %s, got %+v", DefaultKeyctlPerm, info)
+	}
+	if !linkedIn(ring, info.Description) || !linkedIn(info.ID, encodeKeyName(service, user)) || linkedIn(ring, encodeKeyName(service, user)) {
+		t.Errorf("Expected the secret in the keyring of the service only")
+	}
+
+	pw, err := provider.Get(service, "flat")
+	if err != nil || pw != "flat" {
+		t.Errorf("Expected flat password, got %q and %v", pw, err)
+	}
+	users, err := provider.List(service)
+	if err != nil || len(users) != 2 || users[0] != "flat" || users[1] != user {
+		t.Errorf("Expected users [flat %s], got %v and %v", user, users, err)
+	}
+
+	// the flat entry is migrated on write
+	if err := provider.Set(service, "flat", "migrated"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if linkedIn(ring, flatName) || !linkedIn(info.ID, flatName) {
+		t.Errorf("Expected flat key to be moved into the keyring of the service")
+	}
+
+	// a remaining flat entry is removed along with the keyring of the service
+	if _, err := unix.AddKey("user", legacyKeyName(service, "legacy"), []byte("legacy"), ring); err != nil {
+		t.Fatalf("Failed to add legacy key: %v", err)
+	}
+	if err := provider.DeleteAll(service); err != nil {
+		t.Fatalf("Failed to delete all: %v", err)
+	}
+	if _, err := provider.InspectService(service); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected keyring of service to be removed, got %v", err)
+	}
+	if users, err := provider.List(service); err != nil || len(users) != 0 {
+		t.Errorf("Expected no users, got %v and %v", users, err)
+	}
+}
+
+func TestKeyctlProviderServiceKeyringRace(t *testing.T) {
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
+	const service = "test-keyctl-service-keyring-race"
+
+	for round := 0; round < 20; round++ {
+		if err := provider.DeleteAll(service); err != nil {
+			t.Fatalf("Failed to delete all: %v", err)
+		}
+
+		// all goroutines may create the keyring of the service at once
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if err := provider.Set(service, fmt.Sprintf("user-%d", i), password); err != nil {
+					t.Errorf("Failed to set password: %v", err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		if users, err := provider.List(service); err != nil || len(users) != 8 {
+			t.Fatalf("Expected 8 users, got %v and %v", users, err)
+		}
+	}
+
+	if err := provider.DeleteAll(service); err != nil {
+		t.Errorf("Failed to delete all: %v", err)
+	}
+}
+
+func TestParseKeyUsers(t *testing.T) {
+	t.Parallel()
+	data := []byte(`    0:    55 54/54 47/1000000 1279/25000000
+ 1000:     7 7/7 5/200 1120/20000
+`)
+
+	quota, ok, err := parseKeyUsers(data, 1000)
+	if err != nil || !ok || quota != (KeyctlQuota{Keys: 5, MaxKeys: 200, Bytes: 1120, MaxBytes: 20000}) {
+		t.Errorf("Unexpected quota %+v, %v and %v", quota, ok, err)
+	}
+
+	if _, ok, err := parseKeyUsers(data, 1001); ok || err != nil {
+		t.Errorf("Expected unlisted user, got %v and %v", ok, err)
+	}
+
+	if _, _, err := parseKeyUsers([]byte(" 1000:     7 7/7 5/x 1120/20000\n"), 1000); err == nil {
+		t.Errorf("Expected error for malformed line")
+	}
+}
+
+func TestKeyctlProviderQuota(t *testing.T) {
+	t.Parallel()
+	provider := newTestKeyctlProvider(t)
+
+	quota, err := provider.Quota()
+	if err != nil {
+		t.Fatalf("Failed to read quota: %v", err)
+	}
+	if quota.Keys < 1 || quota.Keys > quota.MaxKeys || quota.Bytes > quota.MaxBytes {
+		t.Errorf("Unexpected quota %s", quota)
+	}
+
+	err = keyctlError("set", provider.quotaError(unix.EDQUOT))
+	if !errors.Is(err, ErrQuotaExceeded) || !strings.Contains(err.Error(), "keys") {
+		t.Errorf("Expected ErrQuotaExceeded with usage, got %v", err)
+	}
+}
+
+func TestKeyctlProviderUID(t *testing.T) {
+	t.Parallel()
+
+	nobody, err := osuser.Lookup("nobody")
+	if err != nil {
+		t.Skip("no user nobody")
+	}
+	uid, err := strconv.Atoi(nobody.Uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newKeyctlProvider(KeyctlSession, "", 0, &uid); err == nil {
+		t.Errorf("Expected error for the session keyring of another user")
+	}
+
+	provider, err := newKeyctlProvider(KeyctlPersistent, "", 0, &uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := "go-keyring-test:" + t.Name()
+
+	if os.Geteuid() != 0 {
+		err := provider.Set(service, user, password)
+		if !errors.Is(err, ErrAccessDenied) || !strings.Contains(err.Error(), "CAP_SETUID") {
+			t.Errorf("Expected ErrAccessDenied naming CAP_SETUID, got %v", err)
+		}
+		return
+	}
+	// as root, the secret goes to the real persistent keyring of nobody
+	if !sharedKeyringTests() {
+		t.Skip("set GO_KEYRING_TEST_SHARED_KEYRINGS to write to the persistent keyring of nobody")
+	}
+	t.Cleanup(func() {
+		_ = provider.DeleteAll(service)
+	})
+
+	if err := provider.SetItem(service, user, Item{Label: "label", Secret: []byte(password)}); err != nil {
+		t.Fatalf("Failed to set item: %v", err)
+	}
+
+	ring, err := provider.getKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if own, err := (keyctlProvider{}).getKeyring(); err != nil || own == ring {
+		t.Errorf("Expected the persistent keyring of uid %d, got the caller's and %v", uid, err)
+	}
+
+	info, err := provider.InspectService(service)
+	if err != nil || info.UID != uid {
+		t.Errorf("Expected keyring of the service owned by uid %d, got %+v and %v", uid, info, err)
+	}
+	infos, err := provider.Inspect(service, user)
+	if err != nil || len(infos) != 2 || infos[0].UID != uid || infos[1].UID != uid {
+		t.Errorf("Expected keys owned by uid %d, got %+v and %v", uid, infos, err)
+	}
+
+	if pw, err := provider.Get(service, user); err != nil || pw != password {
+		t.Errorf("Expected password %s, got %q and %v", password, pw, err)
+	}
+}
@@ -0,0 +1,56 @@
+//go:build linux || windows
+
+package keyring
+
+import "strings"
+
+// keyNamePrefix marks key names produced by encodeKeyName. The version allows
+// changing the encoding later while still recognising older names.
+const keyNamePrefix = "go-keyring:v1:"
+
+var (
+	keyNameEscaper   = strings.NewReplacer("%", "%25", ":", "%3A")
+	keyNameUnescaper = strings.NewReplacer("%25", "%", "%3A", ":")
+)
+
+// encodeKeyName combines service and user to a single name for backends that
+// address secrets by one string. Both parts are escaped so that they never
+// contain a colon, which makes the name unambiguous.
+func encodeKeyName(service, user string) string {
+	return encodeKeyNamePrefix(service) + keyNameEscaper.Replace(user)
+}
+
+// encodeKeyNamePrefix returns the prefix shared by the names of all secrets
+// of service, and only by them.
+func encodeKeyNamePrefix(service string) string {
+	return keyNamePrefix + keyNameEscaper.Replace(service) + ":"
+}
+
+// decodeKeyName splits a name produced by encodeKeyName into service and
+// user. ok is false if name wasn't produced by encodeKeyName.
+func decodeKeyName(name string) (service, user string, ok bool) {
+	if !strings.HasPrefix(name, keyNamePrefix) {
+		return "", "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(name, keyNamePrefix), ":")
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return keyNameUnescaper.Replace(parts[0]), keyNameUnescaper.Replace(parts[1]), true
+}
+
+// legacyKeyName is the ambiguous name used before encodeKeyName. It's still
+// read, and entries are migrated to the encoded name when written.
+func legacyKeyName(service, user string) string {
+	return service + ":" + user
+}
+
+// legacyServiceUser returns the user of a legacy name of service. As legacy
+// names are ambiguous, this matches all names starting with the service and a
+// colon, except for encoded names.
+func legacyServiceUser(name, service string) (string, bool) {
+	if strings.HasPrefix(name, keyNamePrefix) || !strings.HasPrefix(name, service+":") {
+		return "", false
+	}
+	return strings.TrimPrefix(name, service+":"), true
+}
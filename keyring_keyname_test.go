@@ -0,0 +1,33 @@
+//go:build linux || windows
+
+package keyring
+
+import "testing"
+
+func TestEncodeKeyName(t *testing.T) {
+	if encodeKeyName("a:b", "c") == encodeKeyName("a", "b:c") {
+		t.Errorf("Expected distinct names for a:b/c and a/b:c")
+	}
+
+	for _, tc := range []struct{ service, user string }{
+		{"service", "user"},
+		{"a:b", "c"},
+		{"a", "b:c"},
+		{"%3A", "100%"},
+		{"", ""},
+	} {
+		name := encodeKeyName(tc.service, tc.user)
+		service, user, ok := decodeKeyName(name)
+		if !ok || service != tc.service || user != tc.user {
+			t.Errorf("Expected %q to decode to %q and %q, got %q, %q and %v", name, tc.service, tc.user, service, user, ok)
+		}
+	}
+
+	if _, _, ok := decodeKeyName(legacyKeyName("service", "user")); ok {
+		t.Errorf("Expected legacy name not to decode")
+	}
+
+	if _, ok := legacyServiceUser(encodeKeyName("service", "user"), "go-keyring"); ok {
+		t.Errorf("Expected encoded name not to match as legacy name")
+	}
+}
@@ -0,0 +1,76 @@
+//go:build linux
+
+package keyring
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// itemMeta is the metadata the file and keyctl backends store next to a
+// secret, as they have no native place for it.
+type itemMeta struct {
+	Label      string            `json:"label,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Created    time.Time         `json:"created"`
+	Modified   time.Time         `json:"modified"`
+	Expires    time.Time         `json:"expires"`
+}
+
+// updateMeta returns the metadata to store for a secret written at now and
+// expiring at expires. The creation time of old is kept, and so are its label
+// and attributes unless item is given.
+func updateMeta(old itemMeta, item *Item, now, expires time.Time) itemMeta {
+	meta := old
+	if item != nil {
+		meta.Label = item.Label
+		meta.Attributes = nil
+		for name, value := range item.Attributes {
+			if name == "service" || name == "username" {
+				continue
+			}
+			if meta.Attributes == nil {
+				meta.Attributes = make(map[string]string, len(item.Attributes))
+			}
+			meta.Attributes[name] = value
+		}
+	}
+	meta.Modified = now
+	meta.Expires = expires
+	if meta.Created.IsZero() {
+		meta.Created = now
+	}
+	return meta
+}
+
+// expired reports whether the secret described by m has expired at now.
+func (m itemMeta) expired(now time.Time) bool {
+	return !m.Expires.IsZero() && !now.Before(m.Expires)
+}
+
+// decodeMeta decodes metadata stored by encodeMeta. Unreadable metadata is
+// treated as missing rather than making the secret inaccessible.
+func decodeMeta(data []byte) itemMeta {
+	var meta itemMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return itemMeta{}
+	}
+	return meta
+}
+
+// encodeMeta encodes meta for storage.
+func encodeMeta(meta itemMeta) ([]byte, error) {
+	return json.Marshal(meta)
+}
+
+// item combines meta with secret.
+func (m itemMeta) item(secret []byte) Item {
+	return Item{
+		Label:      m.Label,
+		Attributes: m.Attributes,
+		Created:    m.Created,
+		Modified:   m.Modified,
+		Expires:    m.Expires,
+		Secret:     secret,
+	}
+}
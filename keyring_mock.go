@@ -1,57 +1,165 @@
 package keyring
 
+import (
+	"context"
+	"sort"
+	"time"
+)
+
 type mockProvider struct {
-	mockStore map[string]map[string]string
+	mockStore map[string]map[string]Item
 	mockError error
 }
 
 // Set stores user and pass in the keyring under the defined service
 // name.
 func (m *mockProvider) Set(service, user, pass string) error {
+	return m.SetContext(context.Background(), service, user, pass)
+}
+
+// SetContext stores user and pass in the keyring under the defined service
+// name.
+func (m *mockProvider) SetContext(ctx context.Context, service, user, pass string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if m.mockError != nil {
+		return m.mockError
+	}
+	item := m.mockStore[service][user]
+	item.Secret = []byte(pass)
+	item.Expires = time.Time{}
+	m.store(service, user, item)
+	return nil
+}
+
+// SetWithTTL stores user and pass in the keyring under the defined service
+// name, expiring after ttl.
+func (m *mockProvider) SetWithTTL(service, user, pass string, ttl time.Duration) error {
+	if m.mockError != nil {
+		return m.mockError
+	}
+	item := m.mockStore[service][user]
+	item.Secret = []byte(pass)
+	item.Expires = time.Now().Add(ttl)
+	m.store(service, user, item)
+	return nil
+}
+
+// SetBytes stores user and data in the keyring under the defined service
+// name.
+func (m *mockProvider) SetBytes(service, user string, data []byte) error {
+	return m.Set(service, user, string(data))
+}
+
+// SetItem stores user and item in the keyring under the defined service
+// name.
+func (m *mockProvider) SetItem(service, user string, item Item) error {
 	if m.mockError != nil {
 		return m.mockError
 	}
+	item.Created = m.mockStore[service][user].Created
+	m.store(service, user, item)
+	return nil
+}
+
+// store stores item, updating its timestamps.
+func (m *mockProvider) store(service, user string, item Item) {
 	if m.mockStore == nil {
-		m.mockStore = make(map[string]map[string]string)
+		m.mockStore = make(map[string]map[string]Item)
 	}
 	if m.mockStore[service] == nil {
-		m.mockStore[service] = make(map[string]string)
+		m.mockStore[service] = make(map[string]Item)
 	}
-	m.mockStore[service][user] = pass
-	return nil
+	item.Modified = time.Now()
+	if item.Created.IsZero() {
+		item.Created = item.Modified
+	}
+	m.mockStore[service][user] = item
+}
+
+// lookup returns the item stored for service and user, removing it if it has
+// expired.
+func (m *mockProvider) lookup(service, user string) (Item, bool) {
+	item, ok := m.mockStore[service][user]
+	if ok && !item.Expires.IsZero() && !time.Now().Before(item.Expires) {
+		delete(m.mockStore[service], user)
+		return Item{}, false
+	}
+	return item, ok
 }
 
 // Get gets a secret from the keyring given a service name and a user.
 func (m *mockProvider) Get(service, user string) (string, error) {
+	return m.GetContext(context.Background(), service, user)
+}
+
+// GetContext gets a secret from the keyring given a service name and a user.
+func (m *mockProvider) GetContext(ctx context.Context, service, user string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	if m.mockError != nil {
 		return "", m.mockError
 	}
-	if b, ok := m.mockStore[service]; ok {
-		if v, ok := b[user]; ok {
-			return v, nil
-		}
+	if item, ok := m.lookup(service, user); ok {
+		return string(item.Secret), nil
 	}
 	return "", ErrNotFound
 }
 
+// GetBytes gets a secret from the keyring given a service name and a user.
+func (m *mockProvider) GetBytes(service, user string) ([]byte, error) {
+	secret, err := m.Get(service, user)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(secret), nil
+}
+
+// GetItem gets a secret and its metadata from the keyring given a service
+// name and a user.
+func (m *mockProvider) GetItem(service, user string) (Item, error) {
+	if m.mockError != nil {
+		return Item{}, m.mockError
+	}
+	if item, ok := m.lookup(service, user); ok {
+		return item, nil
+	}
+	return Item{}, ErrNotFound
+}
+
 // Delete deletes a secret, identified by service & user, from the keyring.
 func (m *mockProvider) Delete(service, user string) error {
+	return m.DeleteContext(context.Background(), service, user)
+}
+
+// DeleteContext deletes a secret, identified by service & user, from the
+// keyring.
+func (m *mockProvider) DeleteContext(ctx context.Context, service, user string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if m.mockError != nil {
 		return m.mockError
 	}
-	if m.mockStore != nil {
-		if _, ok := m.mockStore[service]; ok {
-			if _, ok := m.mockStore[service][user]; ok {
-				delete(m.mockStore[service], user)
-				return nil
-			}
-		}
+	if _, ok := m.lookup(service, user); ok {
+		delete(m.mockStore[service], user)
+		return nil
 	}
 	return ErrNotFound
 }
 
 // DeleteAll deletes all secrets for a given service
 func (m *mockProvider) DeleteAll(service string) error {
+	return m.DeleteAllContext(context.Background(), service)
+}
+
+// DeleteAllContext deletes all secrets for a given service
+func (m *mockProvider) DeleteAllContext(ctx context.Context, service string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if m.mockError != nil {
 		return m.mockError
 	}
@@ -59,13 +167,43 @@ func (m *mockProvider) DeleteAll(service string) error {
 	return nil
 }
 
+// List returns the users which have a secret stored for a given service.
+func (m *mockProvider) List(service string) ([]string, error) {
+	return m.ListContext(context.Background(), service)
+}
+
+// ListContext returns the users which have a secret stored for a given
+// service.
+func (m *mockProvider) ListContext(ctx context.Context, service string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if m.mockError != nil {
+		return nil, m.mockError
+	}
+	users := make([]string, 0, len(m.mockStore[service]))
+	for user := range m.mockStore[service] {
+		if _, ok := m.lookup(service, user); ok {
+			users = append(users, user)
+		}
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+func init() {
+	Register("mock", func(Config) (Keyring, error) {
+		return &mockProvider{}, nil
+	})
+}
+
 // MockInit sets the provider to a mocked memory store
 func MockInit() {
-	provider = &mockProvider{}
+	SetDefault(&mockProvider{})
 }
 
 // MockInitWithError sets the provider to a mocked memory store
 // that returns the given error on all operations
 func MockInitWithError(err error) {
-	provider = &mockProvider{mockError: err}
+	SetDefault(&mockProvider{mockError: err})
 }
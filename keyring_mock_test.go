@@ -1,8 +1,10 @@
 package keyring
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 )
 
 // TestSet tests setting a user and password in the keyring.
@@ -74,6 +76,9 @@ func TestMockWithError(t *testing.T) {
 
 	err = mp.Delete(service, user)
 	assertError(t, err, mp.mockError)
+
+	_, err = mp.List(service)
+	assertError(t, err, mp.mockError)
 }
 
 // TestMockDeleteAll tests deleting all secrets for a given service.
@@ -111,6 +116,202 @@ func TestMockDeleteAll(t *testing.T) {
 	}
 }
 
+// TestMockList tests listing the users of a given service.
+func TestMockList(t *testing.T) {
+	mp := mockProvider{}
+
+	users, err := mp.List(service)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+	if len(users) != 0 {
+		t.Errorf("Expected no users, got %v", users)
+	}
+
+	err = mp.Set(service, user+"2", password)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+
+	err = mp.Set(service, user, password)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+
+	users, err = mp.List(service)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+	if len(users) != 2 || users[0] != user || users[1] != user+"2" {
+		t.Errorf("Expected users [%s %s2], got %v", user, user, users)
+	}
+}
+
+// TestMockContextCanceled tests that operations honour a canceled context.
+func TestMockContextCanceled(t *testing.T) {
+	mp := mockProvider{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := mp.SetContext(ctx, service, user, password)
+	assertError(t, err, context.Canceled)
+
+	_, err = mp.GetContext(ctx, service, user)
+	assertError(t, err, context.Canceled)
+
+	err = mp.DeleteContext(ctx, service, user)
+	assertError(t, err, context.Canceled)
+
+	err = mp.DeleteAllContext(ctx, service)
+	assertError(t, err, context.Canceled)
+
+	_, err = mp.ListContext(ctx, service)
+	assertError(t, err, context.Canceled)
+
+	_, err = mp.Get(service, user)
+	assertError(t, err, ErrNotFound)
+}
+
+// TestNewIndependent tests that keyrings returned by New don't share state.
+func TestNewIndependent(t *testing.T) {
+	k1, err := New(WithMock())
+	if err != nil {
+		t.Fatalf("Should not fail, got: %s", err)
+	}
+
+	k2, err := New(WithMock())
+	if err != nil {
+		t.Fatalf("Should not fail, got: %s", err)
+	}
+
+	err = k1.Set(service, user, password)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+
+	_, err = k2.Get(service, user)
+	assertError(t, err, ErrNotFound)
+}
+
+// TestSetDefault tests replacing the keyring of the package level functions
+// while they are in use.
+func TestSetDefault(t *testing.T) {
+	defer SetDefault(nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			SetDefault(&mockProvider{})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		_, _ = Get(service, user)
+	}
+	<-done
+
+	mp := &mockProvider{}
+	SetDefault(mp)
+
+	err := Set(service, user, password)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+
+	pw, err := mp.Get(service, user)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+
+	if password != pw {
+		t.Errorf("Expected password %s, got %s", password, pw)
+	}
+}
+
+// TestMockItem tests storing and reading a secret with metadata.
+func TestMockItem(t *testing.T) {
+	mp := &mockProvider{}
+	SetDefault(mp)
+	defer SetDefault(nil)
+
+	err := SetItem(service, user, Item{
+		Label:      "label",
+		Attributes: map[string]string{"host": "example.com"},
+		Secret:     []byte(password),
+	})
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+
+	pw, err := Get(service, user)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+	if password != pw {
+		t.Errorf("Expected password %s, got %s", password, pw)
+	}
+
+	item, err := GetItem(service, user)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+	if item.Label != "label" || item.Attributes["host"] != "example.com" {
+		t.Errorf("Expected label and attributes to be kept, got %+v", item)
+	}
+	if item.Created.IsZero() || item.Modified.Before(item.Created) {
+		t.Errorf("Expected created and modified times, got %s and %s", item.Created, item.Modified)
+	}
+
+	_, err = GetItem(service, user+"fake")
+	assertError(t, err, ErrNotFound)
+
+	// keyrings not implementing ItemKeyring can't store metadata
+	_, err = getItem(struct{ Keyring }{mp}, service, user)
+	assertError(t, err, ErrNotSupported)
+}
+
+// TestMockSetWithTTL tests that secrets expire.
+func TestMockSetWithTTL(t *testing.T) {
+	mp := &mockProvider{}
+	SetDefault(mp)
+	defer SetDefault(nil)
+
+	err := SetWithTTL(service, user, password, time.Hour)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+
+	item, err := GetItem(service, user)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+	if item.Expires.IsZero() {
+		t.Errorf("Expected expiry to be set")
+	}
+
+	err = SetWithTTL(service, user, password, time.Millisecond)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	_, err = Get(service, user)
+	assertError(t, err, ErrNotFound)
+
+	users, err := List(service)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+	if len(users) != 0 {
+		t.Errorf("Expected no users, got %v", users)
+	}
+
+	err = SetWithTTL(service, user, password, 0)
+	if err == nil {
+		t.Errorf("Expected error for zero ttl")
+	}
+}
+
 func assertError(t *testing.T, err error, expected error) {
 	if err != expected {
 		t.Errorf("Expected error %s, got %s", expected, err)
@@ -0,0 +1,51 @@
+package keyring
+
+// Option configures a keyring created by New.
+type Option func(*options)
+
+type options struct {
+	// open creates the backend of the keyring.
+	open func() (Keyring, error)
+	// fileDir and repairFilePerm configure the file backends, see
+	// WithFileDir and WithFilePermissionRepair.
+	fileDir        string
+	repairFilePerm bool
+	// keyctlKeyring, keyctlKeyringName and keyctlPerm configure the keyctl
+	// backend, see WithKeyctlKeyring and WithKeyctlPerm.
+	keyctlKeyring     string
+	keyctlKeyringName string
+	keyctlPerm        uint32
+	// uid selects the user whose secrets the keyctl and file backends store
+	// if set, see WithUID.
+	uid *int
+}
+
+// New returns a keyring which is independent of the one used by the package
+// level functions and of other keyrings returned by New. Without options the
+// backend is detected as for the package level functions, see WithAutoDetect.
+// If several options select a backend the last one wins.
+func New(opts ...Option) (Keyring, error) {
+	o := options{open: detectProvider}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o.open()
+}
+
+// WithAutoDetect selects the first usable backend of the platform's
+// preference list, using the following usable ones as fallbacks. On Linux the
+// list is the Secret Service, the kernel keyring and plaintext files.
+func WithAutoDetect() Option {
+	return func(o *options) {
+		o.open = detectProvider
+	}
+}
+
+// WithMock selects an in-memory backend, see MockInit.
+func WithMock() Option {
+	return func(o *options) {
+		o.open = func() (Keyring, error) {
+			return &mockProvider{}, nil
+		}
+	}
+}
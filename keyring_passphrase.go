@@ -0,0 +1,65 @@
+package keyring
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// PassphraseEnv is the environment variable the encrypted file backend reads
+// its passphrase from unless configured otherwise.
+const PassphraseEnv = "GO_KEYRING_PASSPHRASE"
+
+// PassphraseFunc returns the passphrase protecting an encrypted keyring. It's
+// called at most once per keyring, when the keyring is first used. The
+// returned slice is cleared after use.
+type PassphraseFunc func() ([]byte, error)
+
+// PassphraseFromEnv returns a PassphraseFunc reading the passphrase from the
+// environment variable name.
+func PassphraseFromEnv(name string) PassphraseFunc {
+	return func() ([]byte, error) {
+		passphrase, ok := os.LookupEnv(name)
+		if !ok || passphrase == "" {
+			return nil, fmt.Errorf("environment variable %s holding the passphrase is not set", name)
+		}
+		return []byte(passphrase), nil
+	}
+}
+
+// PassphraseFromTerminal returns a PassphraseFunc prompting for the passphrase
+// on the controlling terminal.
+func PassphraseFromTerminal(prompt string) PassphraseFunc {
+	return func() ([]byte, error) {
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			if !term.IsTerminal(int(os.Stdin.Fd())) {
+				return nil, errors.New("no terminal to prompt for the passphrase")
+			}
+			tty = os.Stdin
+		} else {
+			defer tty.Close()
+		}
+
+		fmt.Fprint(tty, prompt)
+		passphrase, err := term.ReadPassword(int(tty.Fd()))
+		fmt.Fprintln(tty)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		return passphrase, nil
+	}
+}
+
+// defaultPassphrase reads the passphrase from the environment variable env if
+// it's set and prompts for it on the terminal otherwise.
+func defaultPassphrase(env string) PassphraseFunc {
+	return func() ([]byte, error) {
+		if passphrase, ok := os.LookupEnv(env); ok && passphrase != "" {
+			return []byte(passphrase), nil
+		}
+		return PassphraseFromTerminal("Passphrase for go-keyring: ")()
+	}
+}
@@ -0,0 +1,120 @@
+package keyring
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Config holds the settings passed to the factory of a backend.
+type Config struct {
+	// Options holds backend specific settings, see the documentation of
+	// the respective backend.
+	Options map[string]string
+}
+
+var (
+	// factoriesMu guards factories.
+	factoriesMu sync.RWMutex
+	// factories holds the registered backends by name.
+	factories = make(map[string]func(Config) (Keyring, error))
+)
+
+// backendPreference lists the backends tried in order by the auto-detection.
+// It is set in the init function by the relevant os file e.g.: keyring_unix.go
+var backendPreference []string
+
+// Register makes a keyring backend available under the given name, e.g. for
+// Open and WithBackend. The factory should return an error if the backend
+// isn't usable in the current environment. As with database/sql, Register
+// panics if it is called twice with the same name or if factory is nil.
+func Register(name string, factory func(Config) (Keyring, error)) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if factory == nil {
+		panic("keyring: Register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("keyring: Register called twice for backend " + name)
+	}
+	factories[name] = factory
+}
+
+// Backends returns a sorted list of the names of the registered backends.
+func Backends() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open creates a keyring using the backend registered under name.
+func Open(name string, cfg Config) (Keyring, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[name]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("keyring: unknown backend %q", name)
+	}
+	return factory(cfg)
+}
+
+// WithBackend selects the backend registered under name.
+func WithBackend(name string, cfg Config) Option {
+	return func(o *options) {
+		o.open = func() (Keyring, error) {
+			return Open(name, cfg)
+		}
+	}
+}
+
+// openPreferred opens the given backends in order, skipping the ones which
+// aren't usable. The first backend opened is used as primary keyring, the
+// following ones as fallbacks, which only store secrets once the primary
+// reports ErrBackendUnavailable, see compositeProvider. Backends which aren't
+// registered are skipped as well, unless the list was given explicitly by the
+// user.
+func openPreferred(names []string, configs map[string]Config, explicit bool) (Keyring, error) {
+	var keyrings []Keyring
+	var failures []string
+	for _, name := range names {
+		factoriesMu.RLock()
+		factory, ok := factories[name]
+		factoriesMu.RUnlock()
+		if !ok {
+			if explicit {
+				return nil, fmt.Errorf("keyring: unknown backend %q, registered backends are: %s",
+					name, strings.Join(Backends(), ", "))
+			}
+			continue
+		}
+
+		k, err := factory(configs[name])
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", name, err))
+			continue
+		}
+		keyrings = append(keyrings, k)
+	}
+
+	if len(keyrings) == 0 {
+		if len(failures) == 0 && !explicit {
+			return fallbackServiceProvider{}, nil
+		}
+		return nil, kindError{
+			kind: ErrBackendUnavailable,
+			err:  fmt.Errorf("none of the allowed backends is usable (%s)", strings.Join(failures, "; ")),
+		}
+	}
+
+	k := keyrings[len(keyrings)-1]
+	for i := len(keyrings) - 2; i >= 0; i-- {
+		k = compositeProvider{primary: keyrings[i], fallback: k}
+	}
+	return k, nil
+}
@@ -0,0 +1,84 @@
+package keyring
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestRegisterOpen tests opening a backend registered by a third party.
+func TestRegisterOpen(t *testing.T) {
+	var got Config
+	Register("test-register-open", func(cfg Config) (Keyring, error) {
+		got = cfg
+		return &mockProvider{}, nil
+	})
+
+	k, err := Open("test-register-open", Config{Options: map[string]string{"key": "value"}})
+	if err != nil {
+		t.Fatalf("Should not fail, got: %s", err)
+	}
+	if got.Options["key"] != "value" {
+		t.Errorf("Expected config to be passed to factory, got %v", got)
+	}
+	if _, ok := k.(*mockProvider); !ok {
+		t.Errorf("Expected keyring from factory, got %T", k)
+	}
+
+	_, err = Open("test-register-unknown", Config{})
+	if err == nil {
+		t.Errorf("Expected error for unknown backend")
+	}
+}
+
+// TestRegisterDuplicate tests that registering a name twice panics.
+func TestRegisterDuplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected Register to panic")
+		}
+	}()
+	Register("mock", func(Config) (Keyring, error) {
+		return &mockProvider{}, nil
+	})
+}
+
+// TestOpenPreferred tests that unusable backends are skipped and the usable
+// ones are chained in order.
+func TestOpenPreferred(t *testing.T) {
+	primary := &mockProvider{}
+	fallback := &mockProvider{}
+	Register("test-preferred-unusable", func(Config) (Keyring, error) {
+		return nil, errors.New("unusable")
+	})
+	Register("test-preferred-primary", func(Config) (Keyring, error) {
+		return primary, nil
+	})
+	Register("test-preferred-fallback", func(Config) (Keyring, error) {
+		return fallback, nil
+	})
+
+	k, err := openPreferred([]string{
+		"test-preferred-unusable",
+		"test-preferred-missing",
+		"test-preferred-primary",
+		"test-preferred-fallback",
+	}, nil, false)
+	if err != nil {
+		t.Fatalf("Should not fail, got: %s", err)
+	}
+
+	c, ok := k.(compositeProvider)
+	if !ok || c.primary != primary || c.fallback != fallback {
+		t.Errorf("Expected composite of primary and fallback, got %#v", k)
+	}
+
+	_, err = openPreferred([]string{"test-preferred-unusable"}, nil, false)
+	if err == nil {
+		t.Errorf("Expected error if no backend is usable")
+	}
+
+	_, err = openPreferred([]string{"test-preferred-missing", "test-preferred-primary"}, nil, true)
+	if err == nil {
+		t.Errorf("Expected error for unknown backend in explicit list")
+	}
+}
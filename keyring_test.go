@@ -1,6 +1,7 @@
 package keyring
 
 import (
+	"bytes"
 	"runtime"
 	"strings"
 	"testing"
@@ -107,6 +108,33 @@ func TestGet(t *testing.T) {
 	}
 }
 
+// TestGetBytes tests getting binary data from the keyring.
+func TestGetBytes(t *testing.T) {
+	data := []byte{0x30, 0x82, 0x00, 0x0a, 0xff, 0xfe, '\n', ' ', 0x00}
+	err := SetBytes(service, user, data)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+
+	got, err := GetBytes(service, user)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+
+	if !bytes.Equal(data, got) {
+		t.Errorf("Expected data %v, got %v", data, got)
+	}
+
+	pw, err := Get(service, user)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+
+	if string(data) != pw {
+		t.Errorf("Expected password %q, got %q", data, pw)
+	}
+}
+
 // TestGetNonExisting tests getting a secret not in the keyring.
 func TestGetNonExisting(t *testing.T) {
 	_, err := Get(service, user+"fake")
@@ -181,3 +209,45 @@ func TestDeleteAllEmptyService(t *testing.T) {
 		t.Errorf("Should not have deleted secret from another service")
 	}
 }
+
+// TestList tests listing the users of a given service.
+func TestList(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("List is not supported on darwin")
+	}
+
+	_ = DeleteAll(service)
+
+	err := Set(service, user, password)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+
+	err = Set(service, user+"2", password+"2")
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+
+	users, err := List(service)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+
+	if len(users) != 2 || users[0] != user || users[1] != user+"2" {
+		t.Errorf("Expected users [%s %s2], got %v", user, user, users)
+	}
+
+	err = DeleteAll(service)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+
+	users, err = List(service)
+	if err != nil {
+		t.Errorf("Should not fail, got: %s", err)
+	}
+
+	if len(users) != 0 {
+		t.Errorf("Expected no users, got %v", users)
+	}
+}
@@ -0,0 +1,61 @@
+//go:build linux
+
+package keyring
+
+import (
+	"fmt"
+	osuser "os/user"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// WithUID makes the keyctl and file backends store the secrets of the user
+// with the given uid instead of those of the caller, e.g. for a daemon running
+// as root which provisions the secrets of a service account.
+//
+// The keyctl backend then uses the persistent keyring of that user, which
+// requires CAP_SETUID, and gives the keys and keyrings it creates to the user,
+// which requires CAP_SYS_ADMIN. The file backends store the secrets below the
+// user's home directory, unless WithFileDir or FileDirEnv select another
+// directory, expect the files to belong to the user and give new ones to
+// them, which requires CAP_CHOWN and CAP_DAC_OVERRIDE. Without these
+// capabilities the backends fail with ErrAccessDenied.
+//
+// Backends opened by name take the "uid" option instead, holding a uid or a
+// user name.
+func WithUID(uid int) Option {
+	return func(o *options) {
+		o.uid = &uid
+	}
+}
+
+// lookupUID returns the uid of value, which is either a uid or a user name.
+func lookupUID(value string) (int, error) {
+	if uid, err := strconv.Atoi(value); err == nil {
+		return uid, nil
+	}
+
+	u, err := osuser.Lookup(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid uid option: %w", err)
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+// hasCapabilities reports whether the calling thread has all of the given
+// effective capabilities.
+func hasCapabilities(caps ...int) bool {
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return false
+	}
+
+	for _, c := range caps {
+		if data[c/32].Effective&(1<<uint(c%32)) == 0 {
+			return false
+		}
+	}
+	return true
+}
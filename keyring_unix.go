@@ -3,7 +3,12 @@
 package keyring
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"time"
 
 	dbus "github.com/godbus/dbus/v5"
 	ss "github.com/zalando/go-keyring/secret_service"
@@ -11,60 +16,176 @@ import (
 
 type secretServiceProvider struct{}
 
+// expiresAttribute is the attribute holding the time, in seconds since the
+// epoch, after which a secret stored by SetWithTTL expires.
+const expiresAttribute = "go-keyring-expires"
+
 // Set stores user and pass in the keyring under the defined service
 // name.
 func (s secretServiceProvider) Set(service, user, pass string) error {
-	svc, err := ss.NewSecretService()
+	return s.SetContext(context.Background(), service, user, pass)
+}
+
+// SetContext stores user and pass in the keyring under the defined service
+// name.
+func (s secretServiceProvider) SetContext(ctx context.Context, service, user, pass string) error {
+	err := s.set(ctx, service, user, nil, time.Time{}, func(session dbus.ObjectPath) ss.Secret {
+		return ss.NewSecret(session, pass)
+	})
+	return ssError("set", err)
+}
+
+// SetBytes stores user and data in the keyring under the defined service
+// name, tagged with a binary content type.
+func (s secretServiceProvider) SetBytes(service, user string, data []byte) error {
+	err := s.set(context.Background(), service, user, nil, time.Time{}, func(session dbus.ObjectPath) ss.Secret {
+		return ss.NewBinarySecret(session, data)
+	})
+	return ssError("set", err)
+}
+
+// SetWithTTL stores user and pass in the keyring under the defined service
+// name. The expiry is kept as an attribute of the item and enforced when
+// reading it.
+func (s secretServiceProvider) SetWithTTL(service, user, pass string, ttl time.Duration) error {
+	err := s.set(context.Background(), service, user, nil, time.Now().Add(ttl), func(session dbus.ObjectPath) ss.Secret {
+		return ss.NewSecret(session, pass)
+	})
+	return ssError("set", err)
+}
+
+// SetItem stores user and item in the keyring under the defined service
+// name, using the item's label and attributes for the Secret Service item.
+func (s secretServiceProvider) SetItem(service, user string, item Item) error {
+	err := s.set(context.Background(), service, user, &item, item.Expires, func(session dbus.ObjectPath) ss.Secret {
+		return ss.NewBinarySecret(session, item.Secret)
+	})
+	return ssError("set", err)
+}
+
+// set stores the secret created by newSecret for the opened session. An
+// existing item is updated in place, keeping its creation time, and label and
+// attributes unless item is given. If expires isn't zero, the secret expires
+// at that time.
+func (s secretServiceProvider) set(ctx context.Context, service, user string, item *Item, expires time.Time, newSecret func(session dbus.ObjectPath) ss.Secret) error {
+	svc, err := newSecretService()
 	if err != nil {
 		return err
 	}
 
 	// open a session
-	session, err := svc.OpenSession()
+	session, err := svc.OpenSessionContext(ctx)
 	if err != nil {
 		return err
 	}
 	defer svc.Close(session)
 
-	attributes := map[string]string{
+	secret := newSecret(session.Path())
+
+	collection := svc.GetLoginCollectionContext(ctx)
+
+	err = svc.UnlockContext(ctx, collection.Path())
+	if err != nil {
+		return err
+	}
+
+	results, err := svc.SearchItemsContext(ctx, collection, map[string]string{
 		"username": user,
 		"service":  service,
+	})
+	if err != nil {
+		return err
 	}
 
-	secret := ss.NewSecret(session.Path(), pass)
-
-	collection := svc.GetLoginCollection()
+	if len(results) == 0 {
+		label, attributes := s.itemProperties(service, user, item, nil, expires)
+		return svc.CreateItemContext(ctx, collection, label, attributes, secret)
+	}
 
-	err = svc.Unlock(collection.Path())
+	existing, err := svc.GetItemPropertiesContext(ctx, results[0])
 	if err != nil {
 		return err
 	}
 
-	err = svc.CreateItem(collection,
-		fmt.Sprintf("Password for '%s' on '%s'", user, service),
-		attributes, secret)
+	err = svc.SetSecretContext(ctx, results[0], secret)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	label, attributes := s.itemProperties(service, user, item, existing, expires)
+	if label != existing.Label {
+		err = svc.SetLabelContext(ctx, results[0], label)
+		if err != nil {
+			return err
+		}
+	}
+
+	return svc.SetAttributesContext(ctx, results[0], attributes)
 }
 
-// findItem looksup an item by service and user.
-func (s secretServiceProvider) findItem(svc *ss.SecretService, service, user string) (dbus.ObjectPath, error) {
-	collection := svc.GetLoginCollection()
+// itemProperties returns the label and attributes to store for a secret. They
+// are taken from item if given, from existing otherwise, falling back to the
+// defaults.
+func (s secretServiceProvider) itemProperties(service, user string, item *Item, existing *ss.ItemProperties, expires time.Time) (string, map[string]string) {
+	var label string
+	var extra map[string]string
+	switch {
+	case item != nil:
+		label, extra = item.Label, item.Attributes
+	case existing != nil:
+		label, extra = existing.Label, existing.Attributes
+	}
+
+	attributes := make(map[string]string, len(extra)+3)
+	for name, value := range extra {
+		attributes[name] = value
+	}
+	attributes["username"] = user
+	attributes["service"] = service
+	delete(attributes, expiresAttribute)
+	if !expires.IsZero() {
+		attributes[expiresAttribute] = strconv.FormatInt(expires.Unix(), 10)
+	}
+
+	if label == "" {
+		label = fmt.Sprintf("Password for '%s' on '%s'", user, service)
+	}
+
+	return label, attributes
+}
+
+// expiresAt returns the expiry stored in attributes, or the zero time if the
+// item doesn't expire.
+func (s secretServiceProvider) expiresAt(attributes map[string]string) time.Time {
+	seconds, err := strconv.ParseInt(attributes[expiresAttribute], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+// expired reports whether the item with the given attributes has expired.
+func (s secretServiceProvider) expired(attributes map[string]string) bool {
+	expires := s.expiresAt(attributes)
+	return !expires.IsZero() && !time.Now().Before(expires)
+}
+
+// findItem looksup an item by service and user. An expired item is deleted
+// and reported as ErrNotFound.
+func (s secretServiceProvider) findItem(ctx context.Context, svc *ss.SecretService, service, user string) (dbus.ObjectPath, error) {
+	collection := svc.GetLoginCollectionContext(ctx)
 
 	search := map[string]string{
 		"username": user,
 		"service":  service,
 	}
 
-	err := svc.Unlock(collection.Path())
+	err := svc.UnlockContext(ctx, collection.Path())
 	if err != nil {
 		return "", err
 	}
 
-	results, err := svc.SearchItems(collection, search)
+	results, err := svc.SearchItemsContext(ctx, collection, search)
 	if err != nil {
 		return "", err
 	}
@@ -73,23 +194,35 @@ func (s secretServiceProvider) findItem(svc *ss.SecretService, service, user str
 		return "", ErrNotFound
 	}
 
+	attributes, err := svc.GetAttributesContext(ctx, results[0])
+	if err != nil {
+		return "", err
+	}
+
+	if s.expired(attributes) {
+		if err := svc.DeleteContext(ctx, results[0]); err != nil {
+			return "", err
+		}
+		return "", ErrNotFound
+	}
+
 	return results[0], nil
 }
 
 // findServiceItems looksup all items by service.
-func (s secretServiceProvider) findServiceItems(svc *ss.SecretService, service string) ([]dbus.ObjectPath, error) {
-	collection := svc.GetLoginCollection()
+func (s secretServiceProvider) findServiceItems(ctx context.Context, svc *ss.SecretService, service string) ([]dbus.ObjectPath, error) {
+	collection := svc.GetLoginCollectionContext(ctx)
 
 	search := map[string]string{
 		"service": service,
 	}
 
-	err := svc.Unlock(collection.Path())
+	err := svc.UnlockContext(ctx, collection.Path())
 	if err != nil {
 		return []dbus.ObjectPath{}, err
 	}
 
-	results, err := svc.SearchItems(collection, search)
+	results, err := svc.SearchItemsContext(ctx, collection, search)
 	if err != nil {
 		return []dbus.ObjectPath{}, err
 	}
@@ -103,65 +236,156 @@ func (s secretServiceProvider) findServiceItems(svc *ss.SecretService, service s
 
 // Get gets a secret from the keyring given a service name and a user.
 func (s secretServiceProvider) Get(service, user string) (string, error) {
-	svc, err := ss.NewSecretService()
+	return s.GetContext(context.Background(), service, user)
+}
+
+// GetContext gets a secret from the keyring given a service name and a user.
+func (s secretServiceProvider) GetContext(ctx context.Context, service, user string) (string, error) {
+	secret, err := s.get(ctx, service, user)
 	if err != nil {
-		return "", err
+		return "", ssError("get", err)
+	}
+
+	return string(secret), nil
+}
+
+// GetBytes gets a secret from the keyring given a service name and a user.
+func (s secretServiceProvider) GetBytes(service, user string) ([]byte, error) {
+	secret, err := s.get(context.Background(), service, user)
+	return secret, ssError("get", err)
+}
+
+// GetItem gets a secret and its metadata from the keyring given a service
+// name and a user.
+func (s secretServiceProvider) GetItem(service, user string) (Item, error) {
+	item, err := s.getItem(context.Background(), service, user)
+	return item, ssError("get", err)
+}
+
+func (s secretServiceProvider) getItem(ctx context.Context, service, user string) (Item, error) {
+	svc, err := newSecretService()
+	if err != nil {
+		return Item{}, err
 	}
 
-	item, err := s.findItem(svc, service, user)
+	path, err := s.findItem(ctx, svc, service, user)
 	if err != nil {
-		return "", err
+		return Item{}, err
 	}
 
+	secret, err := s.secret(ctx, svc, path)
+	if err != nil {
+		return Item{}, err
+	}
+
+	props, err := svc.GetItemPropertiesContext(ctx, path)
+	if err != nil {
+		return Item{}, err
+	}
+
+	// the lookup attributes identifying the item aren't part of the item's
+	// own attributes
+	delete(props.Attributes, "username")
+	delete(props.Attributes, "service")
+
+	expires := s.expiresAt(props.Attributes)
+	delete(props.Attributes, expiresAttribute)
+
+	return Item{
+		Label:      props.Label,
+		Attributes: props.Attributes,
+		Created:    props.Created,
+		Modified:   props.Modified,
+		Expires:    expires,
+		Secret:     secret,
+	}, nil
+}
+
+// get gets the raw secret value of the item identified by service & user.
+func (s secretServiceProvider) get(ctx context.Context, service, user string) ([]byte, error) {
+	svc, err := newSecretService()
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := s.findItem(ctx, svc, service, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.secret(ctx, svc, item)
+}
+
+// secret gets the raw secret value of item.
+func (s secretServiceProvider) secret(ctx context.Context, svc *ss.SecretService, item dbus.ObjectPath) ([]byte, error) {
 	// open a session
-	session, err := svc.OpenSession()
+	session, err := svc.OpenSessionContext(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer svc.Close(session)
 
 	// unlock if invdividual item is locked
-	err = svc.Unlock(item)
+	err = svc.UnlockContext(ctx, item)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	secret, err := svc.GetSecret(item, session.Path())
+	secret, err := svc.GetSecretContext(ctx, item, session.Path())
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return string(secret.Value), nil
+	return secret.Value, nil
 }
 
 // Delete deletes a secret, identified by service & user, from the keyring.
 func (s secretServiceProvider) Delete(service, user string) error {
-	svc, err := ss.NewSecretService()
+	return s.DeleteContext(context.Background(), service, user)
+}
+
+// DeleteContext deletes a secret, identified by service & user, from the
+// keyring.
+func (s secretServiceProvider) DeleteContext(ctx context.Context, service, user string) error {
+	return ssError("delete", s.remove(ctx, service, user))
+}
+
+func (s secretServiceProvider) remove(ctx context.Context, service, user string) error {
+	svc, err := newSecretService()
 	if err != nil {
 		return err
 	}
 
-	item, err := s.findItem(svc, service, user)
+	item, err := s.findItem(ctx, svc, service, user)
 	if err != nil {
 		return err
 	}
 
-	return svc.Delete(item)
+	return svc.DeleteContext(ctx, item)
 }
 
 // DeleteAll deletes all secrets for a given service
 func (s secretServiceProvider) DeleteAll(service string) error {
+	return s.DeleteAllContext(context.Background(), service)
+}
+
+// DeleteAllContext deletes all secrets for a given service
+func (s secretServiceProvider) DeleteAllContext(ctx context.Context, service string) error {
+	return ssError("delete all", s.removeAll(ctx, service))
+}
+
+func (s secretServiceProvider) removeAll(ctx context.Context, service string) error {
 	// if service is empty, do nothing otherwise it might accidentally delete all secrets
 	if service == "" {
 		return ErrNotFound
 	}
 
-	svc, err := ss.NewSecretService()
+	svc, err := newSecretService()
 	if err != nil {
 		return err
 	}
 	// find all items for the service
-	items, err := s.findServiceItems(svc, service)
+	items, err := s.findServiceItems(ctx, svc, service)
 	if err != nil {
 		if err == ErrNotFound {
 			return nil
@@ -169,7 +393,7 @@ func (s secretServiceProvider) DeleteAll(service string) error {
 		return err
 	}
 	for _, item := range items {
-		err = svc.Delete(item)
+		err = svc.DeleteContext(ctx, item)
 		if err != nil {
 			return err
 		}
@@ -177,31 +401,113 @@ func (s secretServiceProvider) DeleteAll(service string) error {
 	return nil
 }
 
-// getFallbackProvider returns the appropriate fallback provider for the platform
-// Defined in platform-specific files (e.g., keyring_keyctl.go for Linux)
-var getFallbackProvider = func() Keyring {
-	return nil
+// List returns the users which have a secret stored for a given service.
+func (s secretServiceProvider) List(service string) ([]string, error) {
+	return s.ListContext(context.Background(), service)
 }
 
-func init() {
-	// Try to initialize Secret Service
-	_, err := ss.NewSecretService()
-	if err == nil {
-		// Secret Service is available
-		provider = secretServiceProvider{}
-	} else {
-		// Secret Service not available, use compositeProvider with fallback
-		// Note: We still try Secret Service as primary for forward compatibility
-		// but will fallback to keyctl if it's not available
-		fallback := getFallbackProvider()
-		if fallback != nil {
-			provider = compositeProvider{
-				primary:  secretServiceProvider{},
-				fallback: fallback,
+// ListContext returns the users which have a secret stored for a given
+// service.
+func (s secretServiceProvider) ListContext(ctx context.Context, service string) ([]string, error) {
+	users, err := s.list(ctx, service)
+	return users, ssError("list", err)
+}
+
+func (s secretServiceProvider) list(ctx context.Context, service string) ([]string, error) {
+	svc, err := newSecretService()
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := s.findServiceItems(ctx, svc, service)
+	if err != nil {
+		if err == ErrNotFound {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	users := make([]string, 0, len(items))
+	for _, item := range items {
+		attributes, err := svc.GetAttributesContext(ctx, item)
+		if err != nil {
+			return nil, err
+		}
+		if s.expired(attributes) {
+			if err := svc.DeleteContext(ctx, item); err != nil {
+				return nil, err
 			}
-		} else {
-			// No fallback available, keep using Secret Service (will error on operations)
-			provider = secretServiceProvider{}
+			continue
 		}
+		users = append(users, attributes["username"])
+	}
+	sort.Strings(users)
+
+	return users, nil
+}
+
+// newSecretService connects to the Secret Service, classifying a failure as
+// ErrBackendUnavailable.
+func newSecretService() (*ss.SecretService, error) {
+	svc, err := ss.NewSecretService()
+	if err != nil {
+		return nil, kindError{ErrBackendUnavailable, err}
 	}
+	return svc, nil
+}
+
+// ssError maps err returned by the Secret Service during op onto the errors of
+// this package.
+func ssError(op string, err error) error {
+	var dbusErr dbus.Error
+	var dbusErrPtr *dbus.Error
+	if errors.As(err, &dbusErrPtr) {
+		dbusErr = *dbusErrPtr
+	} else if !errors.As(err, &dbusErr) {
+		switch {
+		case errors.Is(err, ss.ErrPromptDismissed):
+			err = kindError{ErrPromptDismissed, err}
+		case errors.Is(err, ss.ErrNotUnlocked):
+			err = kindError{ErrLocked, err}
+		}
+		return newBackendError("secret-service", op, err)
+	}
+
+	switch dbusErr.Name {
+	case "org.freedesktop.Secret.Error.NoSuchObject":
+		return ErrNotFound
+	case "org.freedesktop.Secret.Error.IsLocked":
+		err = kindError{ErrLocked, err}
+	case "org.freedesktop.DBus.Error.AccessDenied":
+		err = kindError{ErrAccessDenied, err}
+	case "org.freedesktop.DBus.Error.ServiceUnknown",
+		"org.freedesktop.DBus.Error.NameHasNoOwner",
+		"org.freedesktop.DBus.Error.NoServer",
+		"org.freedesktop.DBus.Error.Disconnected":
+		err = kindError{ErrBackendUnavailable, err}
+	}
+	return newBackendError("secret-service", op, err)
+}
+
+// WithSecretService selects the Secret Service dbus backend.
+func WithSecretService() Option {
+	return func(o *options) {
+		o.open = func() (Keyring, error) {
+			return secretServiceProvider{}, nil
+		}
+	}
+}
+
+func init() {
+	Register("secret-service", func(Config) (Keyring, error) {
+		if _, err := newSecretService(); err != nil {
+			return nil, newBackendError("secret-service", "open", err)
+		}
+		return secretServiceProvider{}, nil
+	})
+
+	// Prefer the Secret Service and fall back to the kernel keyring and
+	// plaintext files where those are available, see keyring_keyctl.go and
+	// keyring_file.go.
+	backendPreference = []string{"secret-service", "keyctl", "file"}
 }
@@ -0,0 +1,475 @@
+//go:build linux
+
+package keyring
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// vaultFileName is the file below the root of the vault backend holding
+	// all secrets.
+	vaultFileName = "vault"
+
+	// vaultMagic starts every vault file. It's followed by the format
+	// version, a flags byte and the payload, which is the JSON encoding of
+	// vaultData, sealed like a file of the encrypted file backend if
+	// vaultEncrypted is set.
+	vaultMagic = "go-keyring-vault"
+
+	vaultVersion = 1
+
+	// vaultEncrypted is the flag of encrypted vaults.
+	vaultEncrypted = 1 << 0
+)
+
+// vaultData is the content of a vault file.
+type vaultData struct {
+	// Services holds the entries by service and user.
+	Services map[string]map[string]vaultEntry `json:"services"`
+}
+
+type vaultEntry struct {
+	Secret []byte   `json:"secret"`
+	Meta   itemMeta `json:"meta"`
+}
+
+// vaultProvider stores all secrets in a single file, which is replaced as a
+// whole on every change. Unlike the file backend, it doesn't reveal services
+// and users through file names.
+type vaultProvider struct {
+	files *fileProvider
+}
+
+// encryptedVaultProvider is a vaultProvider encrypting the vault.
+type encryptedVaultProvider struct {
+	vaultProvider
+}
+
+func init() {
+	Register("vault", func(cfg Config) (Keyring, error) {
+		v := newVaultProvider(&fileProvider{})
+		if err := v.files.configure(cfg); err != nil {
+			return nil, v.files.error("open", err)
+		}
+		return v, nil
+	})
+	Register("encrypted-vault", func(cfg Config) (Keyring, error) {
+		env := cfg.Options["passphrase-env"]
+		if env == "" {
+			env = PassphraseEnv
+		}
+		v := encryptedVaultProvider{newVaultProvider(&fileProvider{crypter: &fileCrypter{passphrase: defaultPassphrase(env)}})}
+		if err := v.files.configure(cfg); err != nil {
+			return nil, v.files.error("open", err)
+		}
+		return v, nil
+	})
+}
+
+// WithVault selects the backend storing all secrets in the single plaintext
+// file go-keyring-vault/vault, see WithFileDir for its location.
+func WithVault() Option {
+	return func(o *options) {
+		o.open = func() (Keyring, error) {
+			f, err := o.newFileProvider(nil)
+			if err != nil {
+				return nil, err
+			}
+			return newVaultProvider(f), nil
+		}
+	}
+}
+
+// WithEncryptedVault selects the backend storing all secrets in a single file
+// like WithVault, encrypted like the files of WithEncryptedFile. If
+// passphrase is nil, it's read from the environment variable PassphraseEnv or
+// prompted for on the terminal.
+func WithEncryptedVault(passphrase PassphraseFunc) Option {
+	if passphrase == nil {
+		passphrase = defaultPassphrase(PassphraseEnv)
+	}
+	return func(o *options) {
+		o.open = func() (Keyring, error) {
+			f, err := o.newFileProvider(&fileCrypter{passphrase: passphrase})
+			if err != nil {
+				return nil, err
+			}
+			return encryptedVaultProvider{newVaultProvider(f)}, nil
+		}
+	}
+}
+
+func newVaultProvider(files *fileProvider) vaultProvider {
+	files.vault = true
+	return vaultProvider{files: files}
+}
+
+// Rekey protects the vault with a new passphrase.
+func (v encryptedVaultProvider) Rekey(passphrase []byte) error {
+	return encryptedFileProvider{v.files}.Rekey(passphrase)
+}
+
+func (v vaultProvider) Set(service, user, password string) error {
+	return v.SetContext(context.Background(), service, user, password)
+}
+
+func (v vaultProvider) SetContext(ctx context.Context, service, user, password string) error {
+	return v.files.error("set", v.set(ctx, service, user, []byte(password), nil, time.Time{}))
+}
+
+func (v vaultProvider) SetBytes(service, user string, data []byte) error {
+	return v.files.error("set", v.set(context.Background(), service, user, data, nil, time.Time{}))
+}
+
+// SetWithTTL stores the secret together with its expiry, which is enforced
+// when reading it.
+func (v vaultProvider) SetWithTTL(service, user, password string, ttl time.Duration) error {
+	return v.files.error("set", v.set(context.Background(), service, user, []byte(password), nil, time.Now().Add(ttl)))
+}
+
+// SetItem stores the secret of item and its metadata.
+func (v vaultProvider) SetItem(service, user string, item Item) error {
+	return v.files.error("set", v.set(context.Background(), service, user, item.Secret, &item, item.Expires))
+}
+
+// set stores data and updates the metadata, replacing label and attributes
+// by those of item if given.
+func (v vaultProvider) set(ctx context.Context, service, user string, data []byte, item *Item, expires time.Time) error {
+	return v.update(ctx, func(vault *vaultData) (bool, error) {
+		users := vault.Services[service]
+		if users == nil {
+			users = make(map[string]vaultEntry)
+			vault.Services[service] = users
+		}
+		users[user] = vaultEntry{
+			Secret: data,
+			Meta:   updateMeta(users[user].Meta, item, time.Now(), expires),
+		}
+		return true, nil
+	})
+}
+
+func (v vaultProvider) Get(service, user string) (string, error) {
+	return v.GetContext(context.Background(), service, user)
+}
+
+func (v vaultProvider) GetContext(ctx context.Context, service, user string) (string, error) {
+	item, err := v.getItem(ctx, service, user)
+	if err != nil {
+		return "", v.files.error("get", err)
+	}
+
+	return string(item.Secret), nil
+}
+
+func (v vaultProvider) GetBytes(service, user string) ([]byte, error) {
+	item, err := v.getItem(context.Background(), service, user)
+	return item.Secret, v.files.error("get", err)
+}
+
+// GetItem gets a secret and its metadata.
+func (v vaultProvider) GetItem(service, user string) (Item, error) {
+	item, err := v.getItem(context.Background(), service, user)
+	return item, v.files.error("get", err)
+}
+
+// getItem reads a secret and its metadata, removing the secret if it has
+// expired.
+func (v vaultProvider) getItem(ctx context.Context, service, user string) (Item, error) {
+	var item Item
+	err := v.update(ctx, func(vault *vaultData) (bool, error) {
+		entry, ok := vault.Services[service][user]
+		if !ok {
+			return false, ErrNotFound
+		}
+		if entry.Meta.expired(time.Now()) {
+			vault.remove(service, user)
+			return true, ErrNotFound
+		}
+		item = entry.Meta.item(entry.Secret)
+		return false, nil
+	})
+	return item, err
+}
+
+func (v vaultProvider) Delete(service, user string) error {
+	return v.DeleteContext(context.Background(), service, user)
+}
+
+func (v vaultProvider) DeleteContext(ctx context.Context, service, user string) error {
+	return v.files.error("delete", v.update(ctx, func(vault *vaultData) (bool, error) {
+		if _, ok := vault.Services[service][user]; !ok {
+			return false, ErrNotFound
+		}
+		vault.remove(service, user)
+		return true, nil
+	}))
+}
+
+func (v vaultProvider) DeleteAll(service string) error {
+	return v.DeleteAllContext(context.Background(), service)
+}
+
+func (v vaultProvider) DeleteAllContext(ctx context.Context, service string) error {
+	if service == "" {
+		return ErrNotFound
+	}
+
+	return v.files.error("delete all", v.update(ctx, func(vault *vaultData) (bool, error) {
+		if _, ok := vault.Services[service]; !ok {
+			return false, nil
+		}
+		delete(vault.Services, service)
+		return true, nil
+	}))
+}
+
+func (v vaultProvider) List(service string) ([]string, error) {
+	return v.ListContext(context.Background(), service)
+}
+
+func (v vaultProvider) ListContext(ctx context.Context, service string) ([]string, error) {
+	users := []string{}
+	err := v.update(ctx, func(vault *vaultData) (bool, error) {
+		changed := false
+		now := time.Now()
+		for user, entry := range vault.Services[service] {
+			// skip, and remove, expired secrets
+			if entry.Meta.expired(now) {
+				vault.remove(service, user)
+				changed = true
+				continue
+			}
+			users = append(users, user)
+		}
+		return changed, nil
+	})
+	if err != nil {
+		return nil, v.files.error("list", err)
+	}
+
+	sort.Strings(users)
+	return users, nil
+}
+
+// ImportFiles copies the secrets of src, which must be a keyring of the file
+// or encrypted file backend, into the vault. Secrets already in the vault are
+// kept. The files of src are left in place. Services containing a slash
+// which are still stored in the legacy layout of older versions aren't
+// found; setting their secrets once moves them to the current layout.
+func (v vaultProvider) ImportFiles(src Keyring) (int, error) {
+	imported, err := v.importFiles(context.Background(), src)
+	return imported, v.files.error("import", err)
+}
+
+func (v vaultProvider) importFiles(ctx context.Context, src Keyring) (int, error) {
+	var files *fileProvider
+	switch src := src.(type) {
+	case *fileProvider:
+		files = src
+	case encryptedFileProvider:
+		files = src.fileProvider
+	default:
+		return 0, ErrNotSupported
+	}
+	if files.vault {
+		return 0, ErrNotSupported
+	}
+
+	// read all secrets before locking the vault, as the file backend takes
+	// its own lock
+	entries := map[string]map[string]vaultEntry{}
+
+	services, err := files.services()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, service := range services {
+		users, err := files.list(ctx, service)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, user := range users {
+			item, err := files.get(ctx, service, user)
+			if err == ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+
+			if entries[service] == nil {
+				entries[service] = make(map[string]vaultEntry)
+			}
+			entries[service][user] = vaultEntry{
+				Secret: item.Secret,
+				Meta: itemMeta{
+					Label:      item.Label,
+					Attributes: item.Attributes,
+					Created:    item.Created,
+					Modified:   item.Modified,
+					Expires:    item.Expires,
+				},
+			}
+		}
+	}
+
+	imported := 0
+	err = v.update(ctx, func(vault *vaultData) (bool, error) {
+		for service, users := range entries {
+			for user, entry := range users {
+				if _, ok := vault.Services[service][user]; ok {
+					continue
+				}
+				if vault.Services[service] == nil {
+					vault.Services[service] = make(map[string]vaultEntry)
+				}
+				vault.Services[service][user] = entry
+				imported++
+			}
+		}
+		return imported > 0, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return imported, nil
+}
+
+// update loads the vault and passes it to fn while holding the lock. The
+// vault is written back if fn reports a change, even if it also returns an
+// error.
+func (v vaultProvider) update(ctx context.Context, fn func(*vaultData) (bool, error)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock, err := v.files.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	vault, err := v.load()
+	if err != nil {
+		return err
+	}
+
+	changed, err := fn(&vault)
+	if changed {
+		if err := v.store(vault); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// path returns the path of the vault file.
+func (v vaultProvider) path() (string, error) {
+	root, err := v.files.root()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, vaultFileName), nil
+}
+
+// load reads the vault, returning an empty one if there's no vault file yet.
+func (v vaultProvider) load() (vaultData, error) {
+	vault := vaultData{Services: map[string]map[string]vaultEntry{}}
+
+	path, err := v.path()
+	if err != nil {
+		return vaultData{}, err
+	}
+
+	data, err := v.files.readFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return vault, nil
+		}
+		return vaultData{}, fmt.Errorf("failed to read vault: %w", err)
+	}
+
+	header := vaultHeader(v.files.crypter != nil)
+	if len(data) < len(header) || string(data[:len(vaultMagic)]) != vaultMagic {
+		return vaultData{}, errors.New("not a vault file")
+	}
+	if data[len(vaultMagic)] != vaultVersion {
+		return vaultData{}, fmt.Errorf("unsupported vault version %d", data[len(vaultMagic)])
+	}
+	if !bytes.Equal(data[:len(header)], header) {
+		if v.files.crypter != nil {
+			return vaultData{}, errors.New("vault isn't encrypted")
+		}
+		return vaultData{}, errors.New("vault is encrypted")
+	}
+
+	payload, err := v.files.open(data[len(header):], header)
+	if err != nil {
+		return vaultData{}, err
+	}
+
+	if err := json.Unmarshal(payload, &vault); err != nil {
+		return vaultData{}, fmt.Errorf("failed to decode vault: %w", err)
+	}
+	if vault.Services == nil {
+		vault.Services = map[string]map[string]vaultEntry{}
+	}
+
+	return vault, nil
+}
+
+// store replaces the vault file atomically.
+func (v vaultProvider) store(vault vaultData) error {
+	path, err := v.path()
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(vault)
+	if err != nil {
+		return err
+	}
+
+	// the header is authenticated along with the payload
+	header := vaultHeader(v.files.crypter != nil)
+	payload, err = v.files.seal(payload, header)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFile(path, append(header, payload...), v.files.owner); err != nil {
+		return fmt.Errorf("failed to write vault: %w", err)
+	}
+	return nil
+}
+
+// vaultHeader returns the header of the current vault format.
+func vaultHeader(encrypted bool) []byte {
+	var flags byte
+	if encrypted {
+		flags |= vaultEncrypted
+	}
+	return append([]byte(vaultMagic), vaultVersion, flags)
+}
+
+// remove removes the secret of service and user, and the service once it
+// has no secrets left.
+func (d *vaultData) remove(service, user string) {
+	delete(d.Services[service], user)
+	if len(d.Services[service]) == 0 {
+		delete(d.Services, service)
+	}
+}
@@ -0,0 +1,169 @@
+//go:build linux
+
+package keyring
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVaultProvider(t *testing.T) {
+	setTempFileDirs(t)
+	provider := newVaultProvider(&fileProvider{})
+
+	if err := provider.Set(service, user, password); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if err := provider.Set(service, user+"2", password); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if err := provider.SetWithTTL(service, user+"3", password, 10*time.Millisecond); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	pw, err := provider.Get(service, user)
+	if err != nil || pw != password {
+		t.Fatalf("Expected password %s, got %q and %v", password, pw, err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	users, err := provider.List(service)
+	if err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+	if len(users) != 2 || users[0] != user || users[1] != user+"2" {
+		t.Errorf("Expected users [%s %s2], got %v", user, user, users)
+	}
+
+	// a single file holds all secrets
+	root, err := provider.files.root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != vaultFileName && entry.Name() != lockFileName {
+			t.Errorf("Unexpected file %s next to the vault", entry.Name())
+		}
+	}
+
+	if err := provider.Delete(service, user); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	_, err = provider.Get(service, user)
+	assertError(t, err, ErrNotFound)
+
+	if err := provider.DeleteAll(service); err != nil {
+		t.Fatalf("Failed to delete all: %v", err)
+	}
+	_, err = provider.Get(service, user+"2")
+	assertError(t, err, ErrNotFound)
+}
+
+func TestEncryptedVaultProvider(t *testing.T) {
+	setTempFileDirs(t)
+	provider := encryptedVaultProvider{newVaultProvider(&fileProvider{crypter: &fileCrypter{passphrase: staticPassphrase("passphrase")}})}
+
+	if err := provider.Set(service, user, password); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	path, err := provider.path()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, vaultHeader(true)) {
+		t.Errorf("Expected vault header, got %q", data[:len(vaultHeader(true))])
+	}
+	if bytes.Contains(data, []byte(service)) || bytes.Contains(data, []byte(password)) {
+		t.Errorf("Expected vault to be encrypted")
+	}
+
+	// the header is authenticated
+	data[len(vaultMagic)+1] = 0
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = newVaultProvider(&fileProvider{}).Get(service, user)
+	if err == nil {
+		t.Errorf("Expected error reading a vault with a modified header")
+	}
+	_, err = provider.Get(service, user)
+	if err == nil {
+		t.Errorf("Expected error reading a vault with a modified header")
+	}
+
+	data[len(vaultMagic)+1] = vaultEncrypted
+	data[len(data)-1] ^= 1
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = provider.Get(service, user)
+	if !errors.Is(err, ErrTampered) {
+		t.Errorf("Expected ErrTampered, got %v", err)
+	}
+}
+
+func TestVaultProviderImportFiles(t *testing.T) {
+	setTempFileDirs(t)
+	files := &fileProvider{}
+
+	if err := files.SetItem(service, user, Item{Label: "label", Secret: []byte(password)}); err != nil {
+		t.Fatalf("Failed to set item: %v", err)
+	}
+	if err := files.Set("a/b", user, password); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	// secrets in the legacy layout are imported as well
+	root, err := files.root()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "legacy"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "legacy", user), []byte(password), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := newVaultProvider(&fileProvider{})
+	if err := provider.Set(service, user, "kept"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+
+	imported, err := provider.ImportFiles(files)
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if imported != 2 {
+		t.Errorf("Expected 2 imported secrets, got %d", imported)
+	}
+
+	for _, tc := range []struct{ service, password string }{
+		{service, "kept"},
+		{"a/b", password},
+		{"legacy", password},
+	} {
+		pw, err := provider.Get(tc.service, user)
+		if err != nil || pw != tc.password {
+			t.Errorf("Expected password %s for %s, got %q and %v", tc.password, tc.service, pw, err)
+		}
+	}
+
+	if _, err := provider.ImportFiles(&mockProvider{}); err != ErrNotSupported {
+		t.Errorf("Expected ErrNotSupported, got %v", err)
+	}
+}
@@ -1,7 +1,8 @@
 package keyring
 
 import (
-	"strings"
+	"context"
+	"sort"
 	"syscall"
 
 	"github.com/danieljoos/wincred"
@@ -11,22 +12,79 @@ type windowsKeychain struct{}
 
 // Get gets a secret from the keyring given a service name and a user.
 func (k windowsKeychain) Get(service, username string) (string, error) {
+	return k.GetContext(context.Background(), service, username)
+}
+
+// GetContext gets a secret from the keyring given a service name and a user.
+func (k windowsKeychain) GetContext(ctx context.Context, service, username string) (string, error) {
+	data, err := k.get(ctx, service, username)
+	if err != nil {
+		return "", wincredError("get", err)
+	}
+
+	return string(data), nil
+}
+
+// GetBytes gets a secret from the keyring given a service name and a user.
+func (k windowsKeychain) GetBytes(service, username string) ([]byte, error) {
+	data, err := k.get(context.Background(), service, username)
+	return data, wincredError("get", err)
+}
+
+func (k windowsKeychain) get(ctx context.Context, service, username string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	cred, err := k.find(service, username)
+	if err != nil {
+		return nil, err
+	}
+
+	return cred.CredentialBlob, nil
+}
+
+// find reads the credential of service and username, falling back to its
+// legacy name.
+func (k windowsKeychain) find(service, username string) (*wincred.GenericCredential, error) {
 	cred, err := wincred.GetGenericCredential(k.credName(service, username))
+	if err == syscall.ERROR_NOT_FOUND {
+		cred, err = wincred.GetGenericCredential(legacyKeyName(service, username))
+	}
 	if err != nil {
 		if err == syscall.ERROR_NOT_FOUND {
-			return "", ErrNotFound
+			return nil, ErrNotFound
 		}
-		return "", err
+		return nil, err
 	}
-
-	return string(cred.CredentialBlob), nil
+	return cred, nil
 }
 
 // Set stores stores user and pass in the keyring under the defined service
 // name.
 func (k windowsKeychain) Set(service, username, password string) error {
+	return k.SetContext(context.Background(), service, username, password)
+}
+
+// SetContext stores stores user and pass in the keyring under the defined
+// service name.
+func (k windowsKeychain) SetContext(ctx context.Context, service, username, password string) error {
+	return wincredError("set", k.set(ctx, service, username, []byte(password)))
+}
+
+// SetBytes stores user and data in the keyring under the defined service
+// name.
+func (k windowsKeychain) SetBytes(service, username string, data []byte) error {
+	return wincredError("set", k.set(context.Background(), service, username, data))
+}
+
+func (k windowsKeychain) set(ctx context.Context, service, username string, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// password may not exceed 2560 bytes (https://github.com/jaraco/keyring/issues/540#issuecomment-968329967)
-	if len(password) > 2560 {
+	if len(data) > 2560 {
 		return ErrSetDataTooBig
 	}
 
@@ -43,24 +101,69 @@ func (k windowsKeychain) Set(service, username, password string) error {
 
 	cred := wincred.NewGenericCredential(k.credName(service, username))
 	cred.UserName = username
-	cred.CredentialBlob = []byte(password)
-	return cred.Write()
-}
+	cred.CredentialBlob = data
+	if err := cred.Write(); err != nil {
+		return err
+	}
 
-// Delete deletes a secret, identified by service & user, from the keyring.
-func (k windowsKeychain) Delete(service, username string) error {
-	cred, err := wincred.GetGenericCredential(k.credName(service, username))
+	// migrate a credential stored under the legacy name
+	legacy, err := wincred.GetGenericCredential(legacyKeyName(service, username))
 	if err != nil {
 		if err == syscall.ERROR_NOT_FOUND {
-			return ErrNotFound
+			return nil
 		}
 		return err
 	}
+	return legacy.Delete()
+}
 
-	return cred.Delete()
+// Delete deletes a secret, identified by service & user, from the keyring.
+func (k windowsKeychain) Delete(service, username string) error {
+	return k.DeleteContext(context.Background(), service, username)
+}
+
+// DeleteContext deletes a secret, identified by service & user, from the
+// keyring.
+func (k windowsKeychain) DeleteContext(ctx context.Context, service, username string) error {
+	return wincredError("delete", k.remove(ctx, service, username))
+}
+
+func (k windowsKeychain) remove(ctx context.Context, service, username string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// remove the credential under both names, in case it wasn't migrated yet
+	found := false
+	for _, name := range []string{k.credName(service, username), legacyKeyName(service, username)} {
+		cred, err := wincred.GetGenericCredential(name)
+		if err != nil {
+			if err == syscall.ERROR_NOT_FOUND {
+				continue
+			}
+			return err
+		}
+		if err := cred.Delete(); err != nil {
+			return err
+		}
+		found = true
+	}
+
+	if !found {
+		return ErrNotFound
+	}
+	return nil
 }
 
 func (k windowsKeychain) DeleteAll(service string) error {
+	return k.DeleteAllContext(context.Background(), service)
+}
+
+func (k windowsKeychain) DeleteAllContext(ctx context.Context, service string) error {
+	return wincredError("delete all", k.removeAll(ctx, service))
+}
+
+func (k windowsKeychain) removeAll(ctx context.Context, service string) error {
 	// if service is empty, do nothing otherwise it might accidentally delete all secrets
 	if service == "" {
 		return ErrNotFound
@@ -71,11 +174,14 @@ func (k windowsKeychain) DeleteAll(service string) error {
 		return err
 	}
 
-	prefix := k.credName(service, "")
 	deletedCount := 0
 
 	for _, cred := range creds {
-		if strings.HasPrefix(cred.TargetName, prefix) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if _, ok := k.serviceUser(cred.TargetName, service); ok {
 			genericCred, err := wincred.GetGenericCredential(cred.TargetName)
 			if err != nil {
 				if err != syscall.ERROR_NOT_FOUND {
@@ -93,11 +199,66 @@ func (k windowsKeychain) DeleteAll(service string) error {
 	return nil
 }
 
-// credName combines service and username to a single string.
+// List returns the users which have a secret stored for a given service.
+func (k windowsKeychain) List(service string) ([]string, error) {
+	return k.ListContext(context.Background(), service)
+}
+
+// ListContext returns the users which have a secret stored for a given
+// service.
+func (k windowsKeychain) ListContext(ctx context.Context, service string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	creds, err := wincred.List()
+	if err != nil {
+		return nil, wincredError("list", err)
+	}
+
+	// a credential might be stored under both names until it's migrated
+	seen := make(map[string]bool)
+	users := []string{}
+
+	for _, cred := range creds {
+		if user, ok := k.serviceUser(cred.TargetName, service); ok && !seen[user] {
+			seen[user] = true
+			users = append(users, user)
+		}
+	}
+	sort.Strings(users)
+
+	return users, nil
+}
+
+// wincredError maps err returned by the credential manager during op onto the
+// errors of this package.
+func wincredError(op string, err error) error {
+	if err == syscall.ERROR_ACCESS_DENIED {
+		err = kindError{ErrAccessDenied, err}
+	}
+	return newBackendError("wincred", op, err)
+}
+
+// credName combines service and username to a single string, see
+// encodeKeyName.
 func (k windowsKeychain) credName(service, username string) string {
-	return service + ":" + username
+	return encodeKeyName(service, username)
+}
+
+// serviceUser returns the user of the credential with the given target name
+// if it belongs to service. Legacy names are matched by prefix, as they were
+// before.
+func (k windowsKeychain) serviceUser(targetName, service string) (string, bool) {
+	if credService, user, ok := decodeKeyName(targetName); ok {
+		return user, credService == service
+	}
+	return legacyServiceUser(targetName, service)
 }
 
 func init() {
-	provider = windowsKeychain{}
+	Register("wincred", func(Config) (Keyring, error) {
+		return windowsKeychain{}, nil
+	})
+	backendPreference = []string{"wincred"}
 }
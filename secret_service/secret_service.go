@@ -1,7 +1,10 @@
 package ss
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"errors"
 
@@ -17,9 +20,23 @@ const (
 	itemInterface        = "org.freedesktop.Secret.Item"
 	sessionInterface     = "org.freedesktop.Secret.Session"
 	promptInterface      = "org.freedesktop.Secret.Prompt"
+	propertiesInterface  = "org.freedesktop.DBus.Properties"
 
 	loginCollectionAlias = "/org/freedesktop/secrets/aliases/default"
 	collectionBasePath   = "/org/freedesktop/secrets/collection/"
+
+	// dismissTimeout bounds the call dismissing a prompt after the caller
+	// has given up waiting for it.
+	dismissTimeout = 5 * time.Second
+)
+
+var (
+	// ErrPromptDismissed is returned if the user dismissed a prompt of the
+	// Secret Service, e.g. the one to unlock a collection.
+	ErrPromptDismissed = errors.New("prompt dismissed")
+	// ErrNotUnlocked is returned if a collection or item couldn't be
+	// unlocked.
+	ErrNotUnlocked = errors.New("not unlocked")
 )
 
 // Secret defines a org.freedesk.Secret.Item secret struct.
@@ -40,6 +57,24 @@ func NewSecret(session dbus.ObjectPath, secret string) Secret {
 	}
 }
 
+// NewBinarySecret initializes a new Secret holding arbitrary binary data.
+func NewBinarySecret(session dbus.ObjectPath, secret []byte) Secret {
+	return Secret{
+		Session:     session,
+		Parameters:  []byte{},
+		Value:       secret,
+		ContentType: "application/octet-stream",
+	}
+}
+
+// ItemProperties defines the properties of a org.freedesktop.Secret.Item.
+type ItemProperties struct {
+	Label      string
+	Attributes map[string]string
+	Created    time.Time
+	Modified   time.Time
+}
+
 // SecretService is an interface for the Secret Service dbus API.
 type SecretService struct {
 	*dbus.Conn
@@ -61,9 +96,14 @@ func NewSecretService() (*SecretService, error) {
 
 // OpenSession opens a secret service session.
 func (s *SecretService) OpenSession() (dbus.BusObject, error) {
+	return s.OpenSessionContext(context.Background())
+}
+
+// OpenSessionContext opens a secret service session.
+func (s *SecretService) OpenSessionContext(ctx context.Context) (dbus.BusObject, error) {
 	var disregard dbus.Variant
 	var sessionPath dbus.ObjectPath
-	err := s.object.Call(serviceInterface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&disregard, &sessionPath)
+	err := s.object.CallWithContext(ctx, serviceInterface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&disregard, &sessionPath)
 	if err != nil {
 		return nil, err
 	}
@@ -74,8 +114,14 @@ func (s *SecretService) OpenSession() (dbus.BusObject, error) {
 // CheckCollectionPath accepts dbus path and returns nil if the path is found
 // in the collection interface (and can be used).
 func (s *SecretService) CheckCollectionPath(path dbus.ObjectPath) error {
+	return s.CheckCollectionPathContext(context.Background(), path)
+}
+
+// CheckCollectionPathContext accepts dbus path and returns nil if the path is
+// found in the collection interface (and can be used).
+func (s *SecretService) CheckCollectionPathContext(ctx context.Context, path dbus.ObjectPath) error {
 	obj := s.Conn.Object(serviceName, servicePath)
-	val, err := obj.GetProperty(collectionsInterface)
+	val, err := getProperty(ctx, obj, collectionsInterface)
 	if err != nil {
 		return err
 	}
@@ -95,8 +141,14 @@ func (s *SecretService) GetCollection(name string) dbus.BusObject {
 
 // GetLoginCollection decides and returns the dbus collection to be used for login.
 func (s *SecretService) GetLoginCollection() dbus.BusObject {
+	return s.GetLoginCollectionContext(context.Background())
+}
+
+// GetLoginCollectionContext decides and returns the dbus collection to be used
+// for login.
+func (s *SecretService) GetLoginCollectionContext(ctx context.Context) dbus.BusObject {
 	path := dbus.ObjectPath(collectionBasePath + "login")
-	if err := s.CheckCollectionPath(path); err != nil {
+	if err := s.CheckCollectionPathContext(ctx, path); err != nil {
 		path = dbus.ObjectPath(loginCollectionAlias)
 	}
 	return s.Object(serviceName, path)
@@ -104,17 +156,25 @@ func (s *SecretService) GetLoginCollection() dbus.BusObject {
 
 // Unlock unlocks a collection.
 func (s *SecretService) Unlock(collection dbus.ObjectPath) error {
+	return s.UnlockContext(context.Background(), collection)
+}
+
+// UnlockContext unlocks a collection.
+func (s *SecretService) UnlockContext(ctx context.Context, collection dbus.ObjectPath) error {
 	var unlocked []dbus.ObjectPath
 	var prompt dbus.ObjectPath
-	err := s.object.Call(serviceInterface+".Unlock", 0, []dbus.ObjectPath{collection}).Store(&unlocked, &prompt)
+	err := s.object.CallWithContext(ctx, serviceInterface+".Unlock", 0, []dbus.ObjectPath{collection}).Store(&unlocked, &prompt)
 	if err != nil {
 		return err
 	}
 
-	_, v, err := s.handlePrompt(prompt)
+	dismissed, v, err := s.handlePrompt(ctx, prompt)
 	if err != nil {
 		return err
 	}
+	if dismissed {
+		return ErrPromptDismissed
+	}
 
 	collections := v.Value()
 	switch c := collections.(type) {
@@ -123,7 +183,7 @@ func (s *SecretService) Unlock(collection dbus.ObjectPath) error {
 	}
 
 	if len(unlocked) != 1 || (collection != loginCollectionAlias && unlocked[0] != collection) {
-		return fmt.Errorf("failed to unlock correct collection '%v'", collection)
+		return fmt.Errorf("failed to unlock correct collection '%v': %w", collection, ErrNotUnlocked)
 	}
 
 	return nil
@@ -131,25 +191,38 @@ func (s *SecretService) Unlock(collection dbus.ObjectPath) error {
 
 // Close closes a secret service dbus session.
 func (s *SecretService) Close(session dbus.BusObject) error {
-	return session.Call(sessionInterface+".Close", 0).Err
+	return s.CloseContext(context.Background(), session)
+}
+
+// CloseContext closes a secret service dbus session.
+func (s *SecretService) CloseContext(ctx context.Context, session dbus.BusObject) error {
+	return session.CallWithContext(ctx, sessionInterface+".Close", 0).Err
 }
 
 // CreateCollection with the supplied label.
 func (s *SecretService) CreateCollection(label string) (dbus.BusObject, error) {
+	return s.CreateCollectionContext(context.Background(), label)
+}
+
+// CreateCollectionContext creates a collection with the supplied label.
+func (s *SecretService) CreateCollectionContext(ctx context.Context, label string) (dbus.BusObject, error) {
 	properties := map[string]dbus.Variant{
 		collectionInterface + ".Label": dbus.MakeVariant(label),
 	}
 	var collection, prompt dbus.ObjectPath
-	err := s.object.Call(serviceInterface+".CreateCollection", 0, properties, "").
+	err := s.object.CallWithContext(ctx, serviceInterface+".CreateCollection", 0, properties, "").
 		Store(&collection, &prompt)
 	if err != nil {
 		return nil, err
 	}
 
-	_, v, err := s.handlePrompt(prompt)
+	dismissed, v, err := s.handlePrompt(ctx, prompt)
 	if err != nil {
 		return nil, err
 	}
+	if dismissed {
+		return nil, ErrPromptDismissed
+	}
 
 	if v.String() != "" {
 		collection = dbus.ObjectPath(v.String())
@@ -161,32 +234,42 @@ func (s *SecretService) CreateCollection(label string) (dbus.BusObject, error) {
 // CreateItem creates an item in a collection, with label, attributes and a
 // related secret.
 func (s *SecretService) CreateItem(collection dbus.BusObject, label string, attributes map[string]string, secret Secret) error {
+	return s.CreateItemContext(context.Background(), collection, label, attributes, secret)
+}
+
+// CreateItemContext creates an item in a collection, with label, attributes
+// and a related secret.
+func (s *SecretService) CreateItemContext(ctx context.Context, collection dbus.BusObject, label string, attributes map[string]string, secret Secret) error {
 	properties := map[string]dbus.Variant{
 		itemInterface + ".Label":      dbus.MakeVariant(label),
 		itemInterface + ".Attributes": dbus.MakeVariant(attributes),
 	}
 
 	var item, prompt dbus.ObjectPath
-	err := collection.Call(collectionInterface+".CreateItem", 0,
+	err := collection.CallWithContext(ctx, collectionInterface+".CreateItem", 0,
 		properties, secret, true).Store(&item, &prompt)
 	if err != nil {
 		return err
 	}
 
-	_, _, err = s.handlePrompt(prompt)
+	dismissed, _, err := s.handlePrompt(ctx, prompt)
 	if err != nil {
 		return err
 	}
+	if dismissed {
+		return ErrPromptDismissed
+	}
 
 	return nil
 }
 
 // handlePrompt checks if a prompt should be handles and handles it by
 // triggering the prompt and waiting for the Secret service daemon to display
-// the prompt to the user.
-func (s *SecretService) handlePrompt(prompt dbus.ObjectPath) (bool, dbus.Variant, error) {
+// the prompt to the user. If ctx is done before the prompt completes, the
+// prompt is dismissed and the context error is returned.
+func (s *SecretService) handlePrompt(ctx context.Context, prompt dbus.ObjectPath) (bool, dbus.Variant, error) {
 	if prompt != dbus.ObjectPath("/") {
-		err := s.AddMatchSignal(dbus.WithMatchObjectPath(prompt),
+		err := s.AddMatchSignalContext(ctx, dbus.WithMatchObjectPath(prompt),
 			dbus.WithMatchInterface(promptInterface),
 		)
 		if err != nil {
@@ -199,20 +282,33 @@ func (s *SecretService) handlePrompt(prompt dbus.ObjectPath) (bool, dbus.Variant
 
 		promptSignal := make(chan *dbus.Signal, 1)
 		s.Signal(promptSignal)
+		defer s.RemoveSignal(promptSignal)
 
-		err = s.Object(serviceName, prompt).Call(promptInterface+".Prompt", 0, "").Err
+		err = s.Object(serviceName, prompt).CallWithContext(ctx, promptInterface+".Prompt", 0, "").Err
 		if err != nil {
 			return false, dbus.MakeVariant(""), err
 		}
 
-		signal := <-promptSignal
-		switch signal.Name {
-		case promptInterface + ".Completed":
-			dismissed := signal.Body[0].(bool)
-			result := signal.Body[1].(dbus.Variant)
-			return dismissed, result, nil
+		for {
+			select {
+			case signal, ok := <-promptSignal:
+				if !ok {
+					return false, dbus.MakeVariant(""), errors.New("connection closed while waiting for prompt")
+				}
+				if signal.Path != prompt || signal.Name != promptInterface+".Completed" {
+					continue
+				}
+				dismissed := signal.Body[0].(bool)
+				result := signal.Body[1].(dbus.Variant)
+				return dismissed, result, nil
+			case <-ctx.Done():
+				// the caller gave up, make sure the prompt doesn't linger
+				dismissCtx, cancel := context.WithTimeout(context.Background(), dismissTimeout)
+				_ = s.Object(serviceName, prompt).CallWithContext(dismissCtx, promptInterface+".Dismiss", 0).Err
+				cancel()
+				return false, dbus.MakeVariant(""), ctx.Err()
+			}
 		}
-
 	}
 
 	return false, dbus.MakeVariant(""), nil
@@ -220,8 +316,13 @@ func (s *SecretService) handlePrompt(prompt dbus.ObjectPath) (bool, dbus.Variant
 
 // SearchItems returns a list of items matching the search object.
 func (s *SecretService) SearchItems(collection dbus.BusObject, search interface{}) ([]dbus.ObjectPath, error) {
+	return s.SearchItemsContext(context.Background(), collection, search)
+}
+
+// SearchItemsContext returns a list of items matching the search object.
+func (s *SecretService) SearchItemsContext(ctx context.Context, collection dbus.BusObject, search interface{}) ([]dbus.ObjectPath, error) {
 	var results []dbus.ObjectPath
-	err := collection.Call(collectionInterface+".SearchItems", 0, search).Store(&results)
+	err := collection.CallWithContext(ctx, collectionInterface+".SearchItems", 0, search).Store(&results)
 	if err != nil {
 		return nil, err
 	}
@@ -231,8 +332,13 @@ func (s *SecretService) SearchItems(collection dbus.BusObject, search interface{
 
 // GetSecret gets secret from an item in a given session.
 func (s *SecretService) GetSecret(itemPath dbus.ObjectPath, session dbus.ObjectPath) (*Secret, error) {
+	return s.GetSecretContext(context.Background(), itemPath, session)
+}
+
+// GetSecretContext gets secret from an item in a given session.
+func (s *SecretService) GetSecretContext(ctx context.Context, itemPath dbus.ObjectPath, session dbus.ObjectPath) (*Secret, error) {
 	var secret Secret
-	err := s.Object(serviceName, itemPath).Call(itemInterface+".GetSecret", 0, session).Store(&secret)
+	err := s.Object(serviceName, itemPath).CallWithContext(ctx, itemInterface+".GetSecret", 0, session).Store(&secret)
 	if err != nil {
 		return nil, err
 	}
@@ -240,18 +346,134 @@ func (s *SecretService) GetSecret(itemPath dbus.ObjectPath, session dbus.ObjectP
 	return &secret, nil
 }
 
+// GetAttributes gets the lookup attributes of an item.
+func (s *SecretService) GetAttributes(itemPath dbus.ObjectPath) (map[string]string, error) {
+	return s.GetAttributesContext(context.Background(), itemPath)
+}
+
+// GetAttributesContext gets the lookup attributes of an item.
+func (s *SecretService) GetAttributesContext(ctx context.Context, itemPath dbus.ObjectPath) (map[string]string, error) {
+	val, err := getProperty(ctx, s.Object(serviceName, itemPath), itemInterface+".Attributes")
+	if err != nil {
+		return nil, err
+	}
+
+	attributes, ok := val.Value().(map[string]string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected attributes type %s", val.Signature())
+	}
+
+	return attributes, nil
+}
+
+// SetSecret replaces the secret of an item.
+func (s *SecretService) SetSecret(itemPath dbus.ObjectPath, secret Secret) error {
+	return s.SetSecretContext(context.Background(), itemPath, secret)
+}
+
+// SetSecretContext replaces the secret of an item.
+func (s *SecretService) SetSecretContext(ctx context.Context, itemPath dbus.ObjectPath, secret Secret) error {
+	return s.Object(serviceName, itemPath).CallWithContext(ctx, itemInterface+".SetSecret", 0, secret).Err
+}
+
+// SetLabel sets the label of an item.
+func (s *SecretService) SetLabel(itemPath dbus.ObjectPath, label string) error {
+	return s.SetLabelContext(context.Background(), itemPath, label)
+}
+
+// SetLabelContext sets the label of an item.
+func (s *SecretService) SetLabelContext(ctx context.Context, itemPath dbus.ObjectPath, label string) error {
+	return setProperty(ctx, s.Object(serviceName, itemPath), itemInterface+".Label", label)
+}
+
+// SetAttributes sets the lookup attributes of an item.
+func (s *SecretService) SetAttributes(itemPath dbus.ObjectPath, attributes map[string]string) error {
+	return s.SetAttributesContext(context.Background(), itemPath, attributes)
+}
+
+// SetAttributesContext sets the lookup attributes of an item.
+func (s *SecretService) SetAttributesContext(ctx context.Context, itemPath dbus.ObjectPath, attributes map[string]string) error {
+	return setProperty(ctx, s.Object(serviceName, itemPath), itemInterface+".Attributes", attributes)
+}
+
+// GetItemProperties gets the label, lookup attributes and timestamps of an
+// item.
+func (s *SecretService) GetItemProperties(itemPath dbus.ObjectPath) (*ItemProperties, error) {
+	return s.GetItemPropertiesContext(context.Background(), itemPath)
+}
+
+// GetItemPropertiesContext gets the label, lookup attributes and timestamps
+// of an item.
+func (s *SecretService) GetItemPropertiesContext(ctx context.Context, itemPath dbus.ObjectPath) (*ItemProperties, error) {
+	var values map[string]dbus.Variant
+	err := s.Object(serviceName, itemPath).CallWithContext(ctx, propertiesInterface+".GetAll", 0, itemInterface).Store(&values)
+	if err != nil {
+		return nil, err
+	}
+
+	var props ItemProperties
+	if label, ok := values["Label"].Value().(string); ok {
+		props.Label = label
+	}
+	if attributes, ok := values["Attributes"].Value().(map[string]string); ok {
+		props.Attributes = attributes
+	}
+	if created, ok := values["Created"].Value().(uint64); ok && created != 0 {
+		props.Created = time.Unix(int64(created), 0)
+	}
+	if modified, ok := values["Modified"].Value().(uint64); ok && modified != 0 {
+		props.Modified = time.Unix(int64(modified), 0)
+	}
+
+	return &props, nil
+}
+
 // Delete deletes an item from the collection.
 func (s *SecretService) Delete(itemPath dbus.ObjectPath) error {
+	return s.DeleteContext(context.Background(), itemPath)
+}
+
+// DeleteContext deletes an item from the collection.
+func (s *SecretService) DeleteContext(ctx context.Context, itemPath dbus.ObjectPath) error {
 	var prompt dbus.ObjectPath
-	err := s.Object(serviceName, itemPath).Call(itemInterface+".Delete", 0).Store(&prompt)
+	err := s.Object(serviceName, itemPath).CallWithContext(ctx, itemInterface+".Delete", 0).Store(&prompt)
 	if err != nil {
 		return err
 	}
 
-	_, _, err = s.handlePrompt(prompt)
+	dismissed, _, err := s.handlePrompt(ctx, prompt)
 	if err != nil {
 		return err
 	}
+	if dismissed {
+		return ErrPromptDismissed
+	}
 
 	return nil
 }
+
+// setProperty writes a property of obj, honouring ctx.
+func setProperty(ctx context.Context, obj dbus.BusObject, property string, value interface{}) error {
+	idx := strings.LastIndex(property, ".")
+	if idx == -1 {
+		return fmt.Errorf("invalid property name %q", property)
+	}
+
+	return obj.CallWithContext(ctx, propertiesInterface+".Set", 0, property[:idx], property[idx+1:], dbus.MakeVariant(value)).Err
+}
+
+// getProperty reads a property of obj, honouring ctx.
+func getProperty(ctx context.Context, obj dbus.BusObject, property string) (dbus.Variant, error) {
+	idx := strings.LastIndex(property, ".")
+	if idx == -1 {
+		return dbus.Variant{}, fmt.Errorf("invalid property name %q", property)
+	}
+
+	var val dbus.Variant
+	err := obj.CallWithContext(ctx, propertiesInterface+".Get", 0, property[:idx], property[idx+1:]).Store(&val)
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+
+	return val, nil
+}